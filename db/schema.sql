@@ -1,33 +1,159 @@
+DROP TABLE IF EXISTS smart_list;
+DROP TABLE IF EXISTS todo_template_share;
+DROP TABLE IF EXISTS todo_template_item;
+DROP TABLE IF EXISTS todo_template;
+DROP TABLE IF EXISTS todo_item_note;
+DROP TABLE IF EXISTS todo_item_label;
+DROP TABLE IF EXISTS label;
 DROP TABLE IF EXISTS todo_item;
 DROP TABLE IF EXISTS todo_list;
+DROP TABLE IF EXISTS folder;
 DROP TABLE IF EXISTS account;
 
 CREATE TABLE account (
     id INT PRIMARY KEY AUTO_INCREMENT,
     username VARCHAR(50) NOT NULL UNIQUE,
     password_hash CHAR(60) NOT NULL,
+    timezone VARCHAR(64) NOT NULL DEFAULT 'UTC',
     created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
         ON UPDATE CURRENT_TIMESTAMP
 );
 
-CREATE TABLE todo_list (
+CREATE TABLE folder (
     id INT PRIMARY KEY AUTO_INCREMENT,
     account_id INT NOT NULL,
+    parent_id INT,
     name VARCHAR(50) NOT NULL,
+    position VARCHAR(255) CHARACTER SET ascii COLLATE ascii_bin NOT NULL,
     created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
         ON UPDATE CURRENT_TIMESTAMP,
+    INDEX (account_id, position),
     FOREIGN KEY (account_id) REFERENCES account(id)
+        ON UPDATE RESTRICT ON DELETE RESTRICT,
+    FOREIGN KEY (parent_id) REFERENCES folder(id)
         ON UPDATE RESTRICT ON DELETE RESTRICT
 );
 
+CREATE TABLE todo_list (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    account_id INT NOT NULL,
+    folder_id INT,
+    name VARCHAR(50) NOT NULL,
+    position VARCHAR(255) CHARACTER SET ascii COLLATE ascii_bin NOT NULL,
+    version INT NOT NULL DEFAULT 1,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+        ON UPDATE CURRENT_TIMESTAMP,
+    INDEX (account_id, position),
+    FULLTEXT (name),
+    FOREIGN KEY (account_id) REFERENCES account(id)
+        ON UPDATE RESTRICT ON DELETE RESTRICT,
+    FOREIGN KEY (folder_id) REFERENCES folder(id)
+        ON UPDATE RESTRICT ON DELETE SET NULL
+);
+
 CREATE TABLE todo_item (
     id INT PRIMARY KEY AUTO_INCREMENT,
     todo_list_id INT NOT NULL,
     description VARCHAR(100) NOT NULL,
     completed BOOLEAN NOT NULL,
+    position VARCHAR(255) CHARACTER SET ascii COLLATE ascii_bin NOT NULL,
+    version INT NOT NULL DEFAULT 1,
+    due_at DATETIME,
+    due_all_day BOOLEAN NOT NULL DEFAULT FALSE,
+    remind_at DATETIME,
+    reminder_sent_at DATETIME,
+    reminder_lease_owner VARCHAR(64),
+    reminder_lease_until DATETIME,
+    recurrence VARCHAR(255) NOT NULL DEFAULT '',
+    priority TINYINT NOT NULL DEFAULT 0,
+    parent_item_id INT,
+    completed_at DATETIME,
+    completed_by_account_id INT,
     created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    INDEX (todo_list_id, position),
+    INDEX (todo_list_id, due_at),
+    INDEX (remind_at),
+    FULLTEXT (description),
     FOREIGN KEY (todo_list_id) REFERENCES todo_list(id)
+        ON UPDATE RESTRICT ON DELETE RESTRICT,
+    FOREIGN KEY (parent_item_id) REFERENCES todo_item(id)
+        ON UPDATE RESTRICT ON DELETE CASCADE,
+    FOREIGN KEY (completed_by_account_id) REFERENCES account(id)
+        ON UPDATE RESTRICT ON DELETE SET NULL
+);
+
+CREATE TABLE label (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    account_id INT NOT NULL,
+    name VARCHAR(30) NOT NULL,
+    color CHAR(7) CHARACTER SET ascii NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (account_id, name),
+    FOREIGN KEY (account_id) REFERENCES account(id)
+        ON UPDATE RESTRICT ON DELETE RESTRICT
+);
+
+CREATE TABLE todo_item_label (
+    todo_item_id INT NOT NULL,
+    label_id INT NOT NULL,
+    PRIMARY KEY (todo_item_id, label_id),
+    INDEX (label_id),
+    FOREIGN KEY (todo_item_id) REFERENCES todo_item(id)
+        ON UPDATE RESTRICT ON DELETE CASCADE,
+    FOREIGN KEY (label_id) REFERENCES label(id)
+        ON UPDATE RESTRICT ON DELETE CASCADE
+);
+
+CREATE TABLE todo_item_note (
+    todo_item_id INT PRIMARY KEY,
+    body TEXT NOT NULL,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FULLTEXT (body),
+    FOREIGN KEY (todo_item_id) REFERENCES todo_item(id)
+        ON UPDATE RESTRICT ON DELETE CASCADE
+);
+
+CREATE TABLE todo_template (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    account_id INT NOT NULL,
+    name VARCHAR(50) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (account_id) REFERENCES account(id)
+        ON UPDATE RESTRICT ON DELETE RESTRICT
+);
+
+CREATE TABLE todo_template_item (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    todo_template_id INT NOT NULL,
+    description VARCHAR(100) NOT NULL,
+    position VARCHAR(255) CHARACTER SET ascii COLLATE ascii_bin NOT NULL,
+    INDEX (todo_template_id, position),
+    FOREIGN KEY (todo_template_id) REFERENCES todo_template(id)
+        ON UPDATE RESTRICT ON DELETE CASCADE
+);
+
+CREATE TABLE todo_template_share (
+    todo_template_id INT NOT NULL,
+    account_id INT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (todo_template_id, account_id),
+    FOREIGN KEY (todo_template_id) REFERENCES todo_template(id)
+        ON UPDATE RESTRICT ON DELETE CASCADE,
+    FOREIGN KEY (account_id) REFERENCES account(id)
+        ON UPDATE RESTRICT ON DELETE RESTRICT
+);
+
+CREATE TABLE smart_list (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    account_id INT NOT NULL,
+    name VARCHAR(50) NOT NULL,
+    query VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    INDEX (account_id, name),
+    FOREIGN KEY (account_id) REFERENCES account(id)
         ON UPDATE RESTRICT ON DELETE RESTRICT
 );
@@ -2,21 +2,27 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"encoding/json"
 	"flag"
+	"fmt"
 	"net"
 	"net/http"
+	"os"
 	"time"
 	"todo-app/todo"
 
 	"github.com/go-redis/redis/v8"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/golang/glog"
+	"github.com/golang/protobuf/proto"
 	"github.com/gorilla/mux"
 	"github.com/grpc-ecosystem/grpc-gateway/runtime"
 	"github.com/jmoiron/sqlx"
 	"github.com/rs/cors"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func runService(service *todo.Service) {
@@ -25,7 +31,9 @@ func runService(service *todo.Service) {
 		glog.Fatal(err)
 	}
 
-	opts := []grpc.ServerOption{}
+	opts := []grpc.ServerOption{
+		grpc.UnaryInterceptor(todo.UnaryErrorInterceptor),
+	}
 
 	server := grpc.NewServer(opts...)
 	todo.RegisterTodoAppServer(server, service)
@@ -47,13 +55,54 @@ func configGateway(ctx context.Context, mux *runtime.ServeMux) {
 	}
 }
 
+type preconditionFailedWriter struct {
+	http.ResponseWriter
+}
+
+func (w preconditionFailedWriter) WriteHeader(code int) {
+	if code == http.StatusBadRequest {
+		code = http.StatusPreconditionFailed
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// FAILED_PRECONDITION is translated to 412 Precondition Failed
+// instead of the default 400 Bad Request
+func httpError(ctx context.Context, mux *runtime.ServeMux,
+	marshaler runtime.Marshaler, w http.ResponseWriter,
+	r *http.Request, err error,
+) {
+	if status.Code(err) == codes.FailedPrecondition {
+		w = preconditionFailedWriter{w}
+	}
+	runtime.DefaultHTTPError(ctx, mux, marshaler, w, r, err)
+}
+
+// responses containing a single todo list or item have an ETag header
+// with its version, to be sent back in If-Match
+func setETagHeader(ctx context.Context, w http.ResponseWriter, msg proto.Message) error {
+	version := int32(0)
+	switch m := msg.(type) {
+	case interface{ GetTodo() *todo.TodoList }:
+		version = m.GetTodo().GetVersion()
+	case interface{ GetItem() *todo.TodoItem }:
+		version = m.GetItem().GetVersion()
+	}
+
+	if version != 0 {
+		w.Header().Set("ETag", fmt.Sprintf(`"%d"`, version))
+	}
+	return nil
+}
+
 func setupCORSConfig() *cors.Cors {
 	return cors.New(cors.Options{
 		AllowedOrigins: []string{"http://localhost:5000"},
-		AllowedHeaders: []string{"Authorization", "Content-Type", "X-Auth-Token"},
-		ExposedHeaders: []string{"X-Auth-Token"},
+		AllowedHeaders: []string{"Authorization", "Content-Type", "X-Auth-Token", "If-Match"},
+		ExposedHeaders: []string{"X-Auth-Token", "ETag"},
 		AllowedMethods: []string{http.MethodGet, http.MethodPost,
-			http.MethodOptions, http.MethodPut, http.MethodDelete},
+			http.MethodOptions, http.MethodPut, http.MethodPatch,
+			http.MethodDelete},
 	})
 }
 
@@ -72,7 +121,10 @@ func runGateway(gateway *todo.Gateway) {
 	ctx := context.Background()
 
 	r := mux.NewRouter()
-	grpcRouter := runtime.NewServeMux()
+	runtime.HTTPError = httpError
+	grpcRouter := runtime.NewServeMux(
+		runtime.WithForwardResponseOption(setETagHeader),
+	)
 	c := setupCORSConfig()
 
 	configGateway(ctx, grpcRouter)
@@ -81,13 +133,33 @@ func runGateway(gateway *todo.Gateway) {
 	r.Handle("/accounts", grpcRouter).
 		Methods(http.MethodPost)
 
+	r.Handle("/accounts/me/timezone",
+		gateway.Authenticated(grpcRouter)).
+		Methods(http.MethodPut)
+
 	r.Handle("/todos",
 		gateway.Authenticated(grpcRouter)).
 		Methods(http.MethodPost, http.MethodPut, http.MethodGet)
 
 	r.Handle("/todos/{id}",
 		gateway.Authenticated(grpcRouter)).
-		Methods(http.MethodDelete)
+		Methods(http.MethodGet, http.MethodDelete)
+
+	r.Handle("/todos/{id}/duplicate",
+		gateway.Authenticated(grpcRouter)).
+		Methods(http.MethodPost)
+
+	r.Handle("/todos/{todo_list_id}/folder",
+		gateway.Authenticated(grpcRouter)).
+		Methods(http.MethodPut)
+
+	r.Handle("/todos/{todo_list_id}/items",
+		gateway.Authenticated(grpcRouter)).
+		Methods(http.MethodPost)
+
+	r.Handle("/todos/{id}/position",
+		gateway.Authenticated(grpcRouter)).
+		Methods(http.MethodPut)
 
 	r.Handle("/todo-items",
 		gateway.Authenticated(grpcRouter)).
@@ -97,6 +169,10 @@ func runGateway(gateway *todo.Gateway) {
 		gateway.Authenticated(grpcRouter)).
 		Methods(http.MethodGet)
 
+	r.Handle("/todo-items/{id}",
+		gateway.Authenticated(grpcRouter)).
+		Methods(http.MethodPatch, http.MethodDelete)
+
 	r.Handle("/todo-items/completed",
 		gateway.Authenticated(grpcRouter)).
 		Methods(http.MethodPut)
@@ -105,6 +181,98 @@ func runGateway(gateway *todo.Gateway) {
 		gateway.Authenticated(grpcRouter)).
 		Methods(http.MethodDelete)
 
+	r.Handle("/todo-items/quick-add",
+		gateway.Authenticated(grpcRouter)).
+		Methods(http.MethodPost)
+
+	r.Handle("/todo-items/move",
+		gateway.Authenticated(grpcRouter)).
+		Methods(http.MethodPost)
+
+	r.Handle("/todo-items/{id}/labels",
+		gateway.Authenticated(grpcRouter)).
+		Methods(http.MethodPost)
+
+	r.Handle("/todo-items/{id}/labels/{label_id}",
+		gateway.Authenticated(grpcRouter)).
+		Methods(http.MethodDelete)
+
+	r.Handle("/todo-items/{id}/detail",
+		gateway.Authenticated(grpcRouter)).
+		Methods(http.MethodGet)
+
+	r.Handle("/todo-items/{id}/notes",
+		gateway.Authenticated(grpcRouter)).
+		Methods(http.MethodPut)
+
+	r.Handle("/todo-items/{id}/skip",
+		gateway.Authenticated(grpcRouter)).
+		Methods(http.MethodPost)
+
+	r.Handle("/todo-items/{id}/position",
+		gateway.Authenticated(grpcRouter)).
+		Methods(http.MethodPut)
+
+	r.Handle("/folders",
+		gateway.Authenticated(grpcRouter)).
+		Methods(http.MethodPost)
+
+	r.Handle("/folders/{id}",
+		gateway.Authenticated(grpcRouter)).
+		Methods(http.MethodPut, http.MethodDelete)
+
+	r.Handle("/labels",
+		gateway.Authenticated(grpcRouter)).
+		Methods(http.MethodPost, http.MethodGet)
+
+	r.Handle("/labels/{id}",
+		gateway.Authenticated(grpcRouter)).
+		Methods(http.MethodPut, http.MethodDelete)
+
+	r.Handle("/labels/{label_id}/items",
+		gateway.Authenticated(grpcRouter)).
+		Methods(http.MethodGet)
+
+	r.Handle("/batch",
+		gateway.Authenticated(grpcRouter)).
+		Methods(http.MethodPost)
+
+	r.Handle("/search",
+		gateway.Authenticated(grpcRouter)).
+		Methods(http.MethodGet)
+
+	r.Handle("/smart-lists",
+		gateway.Authenticated(grpcRouter)).
+		Methods(http.MethodPost)
+
+	r.Handle("/smart-lists/{id}",
+		gateway.Authenticated(grpcRouter)).
+		Methods(http.MethodPut, http.MethodDelete)
+
+	r.Handle("/smart-lists/{id}/items",
+		gateway.Authenticated(grpcRouter)).
+		Methods(http.MethodGet)
+
+	r.Handle("/templates",
+		gateway.Authenticated(grpcRouter)).
+		Methods(http.MethodPost, http.MethodGet)
+
+	r.Handle("/templates/{template_id}",
+		gateway.Authenticated(grpcRouter)).
+		Methods(http.MethodDelete)
+
+	r.Handle("/templates/{template_id}/instantiate",
+		gateway.Authenticated(grpcRouter)).
+		Methods(http.MethodPost)
+
+	r.Handle("/templates/{template_id}/shares",
+		gateway.Authenticated(grpcRouter)).
+		Methods(http.MethodPost)
+
+	r.Handle("/templates/{template_id}/shares/{username}",
+		gateway.Authenticated(grpcRouter)).
+		Methods(http.MethodDelete)
+
 	r.Handle("/login",
 		gateway.Authenticated(http.HandlerFunc(loginHandler))).
 		Methods(http.MethodPost)
@@ -136,16 +304,64 @@ func connectToRedis() *redis.Client {
 	return client
 }
 
+// without a configured secret, page tokens are only valid
+// until the server restarts
+func pageTokenSecret(configured string) []byte {
+	if configured != "" {
+		return []byte(configured)
+	}
+
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	glog.Warning("page-token-secret is not set, using a random one")
+	return secret
+}
+
+// webhookURL takes precedence over logFile, "-" logs to stdout
+func reminderNotifier(webhookURL, logFile string) todo.Notifier {
+	if webhookURL != "" {
+		return todo.NewWebhookNotifier(webhookURL)
+	}
+	if logFile == "-" {
+		return todo.NewLogNotifier(os.Stdout)
+	}
+
+	file, err := os.OpenFile(logFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	return todo.NewLogNotifier(file)
+}
+
 func main() {
+	secret := flag.String("page-token-secret", "",
+		"secret used to sign page tokens, must be the same on every instance")
+	reminders := flag.Bool("reminders", true,
+		"run the scheduler delivering the reminders of todo items")
+	reminderInterval := flag.Duration("reminder-interval", 30*time.Second,
+		"how often the due reminders are polled")
+	reminderWebhook := flag.String("reminder-webhook", "",
+		"URL receiving the reminders as JSON POST requests")
+	reminderLog := flag.String("reminder-log", "-",
+		"file the reminders are written to when no webhook is set, - for stdout")
 	flag.Parse()
 
 	source := "root:1@tcp(127.0.0.1:3306)/todoapp?parseTime=true"
 	db := sqlx.MustConnect("mysql", source)
 	redisClient := connectToRedis()
 
-	service := todo.NewService(db)
+	service := todo.NewService(db, pageTokenSecret(*secret))
 	go runService(service)
 
+	if *reminders {
+		notifier := reminderNotifier(*reminderWebhook, *reminderLog)
+		scheduler := todo.NewReminderScheduler(db, notifier, *reminderInterval)
+		go scheduler.Run(context.Background())
+	}
+
 	gateway := todo.NewGateway(db, redisClient)
 	runGateway(gateway)
 }
@@ -0,0 +1,369 @@
+// Package quickadd parses a todo item typed in one line, like
+//
+//	Pay rent tomorrow 9am #finance !high every month
+//
+// into its description, due date, labels, priority and recurrence.
+// The words which are not recognized make the description.
+package quickadd
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Priorities, the same as the priorities of the todo items
+const (
+	PriorityNone = iota
+	PriorityLow
+	PriorityMedium
+	PriorityHigh
+	PriorityUrgent
+)
+
+// Result is a parsed line
+type Result struct {
+	Description string
+	// zero without due date, in the location of now otherwise
+	Due time.Time
+	// the due date has no time, Due is the start of the day
+	AllDay bool
+	// without the #, in the order of the line
+	Labels   []string
+	Priority int
+	// a recurrence rule like FREQ=WEEKLY;BYDAY=MO, empty if none
+	Recurrence string
+	// name of the list after @, empty for the default list
+	List string
+}
+
+var priorities = map[string]int{
+	"low":    PriorityLow,
+	"medium": PriorityMedium,
+	"high":   PriorityHigh,
+	"urgent": PriorityUrgent,
+	"1":      PriorityLow,
+	"2":      PriorityMedium,
+	"3":      PriorityHigh,
+	"4":      PriorityUrgent,
+}
+
+var weekdays = map[string]time.Weekday{
+	"monday":    time.Monday,
+	"tuesday":   time.Tuesday,
+	"wednesday": time.Wednesday,
+	"thursday":  time.Thursday,
+	"friday":    time.Friday,
+	"saturday":  time.Saturday,
+	"sunday":    time.Sunday,
+}
+
+var weekdayRuleNames = map[time.Weekday]string{
+	time.Monday:    "MO",
+	time.Tuesday:   "TU",
+	time.Wednesday: "WE",
+	time.Thursday:  "TH",
+	time.Friday:    "FR",
+	time.Saturday:  "SA",
+	time.Sunday:    "SU",
+}
+
+// frequencies of "every <unit>" and "every n <unit>s"
+var frequencies = map[string]string{
+	"day":    "DAILY",
+	"days":   "DAILY",
+	"week":   "WEEKLY",
+	"weeks":  "WEEKLY",
+	"month":  "MONTHLY",
+	"months": "MONTHLY",
+}
+
+var (
+	isoDateRegexp = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+	// 9am, 9:30pm, 17:00
+	clockRegexp = regexp.MustCompile(`^(\d{1,2})(?::(\d{2}))?(am|pm)?$`)
+)
+
+type parser struct {
+	words []string
+	// lower case words
+	lower []string
+	now   time.Time
+
+	// the date or the time found
+	date    time.Time
+	hasDate bool
+	hour    int
+	minute  int
+	hasTime bool
+	// the weekdays of "every monday" or "every weekday",
+	// the first due date without a date is one of them
+	everyWeekdays []time.Weekday
+
+	result Result
+}
+
+func (p *parser) word(i int) string {
+	if i < len(p.lower) {
+		return p.lower[i]
+	}
+	return ""
+}
+
+func (p *parser) today() time.Time {
+	y, m, d := p.now.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, p.now.Location())
+}
+
+// the day on or after today
+func (p *parser) nextWeekday(day time.Weekday) time.Time {
+	return nextWeekdayFrom(p.today(), day)
+}
+
+// the first of the days on or after from
+func nextWeekdayFrom(from time.Time, days ...time.Weekday) time.Time {
+	next := from.AddDate(0, 0, 7)
+	for _, day := range days {
+		t := from.AddDate(0, 0, (int(day)-int(from.Weekday())+7)%7)
+		if t.Before(next) {
+			next = t
+		}
+	}
+	return next
+}
+
+// the number of words of the date starting at i, 0 if there is none
+func (p *parser) parseDate(i int) int {
+	w := p.word(i)
+	switch w {
+	case "today":
+		p.date = p.today()
+	case "tomorrow":
+		p.date = p.today().AddDate(0, 0, 1)
+	case "on":
+		n := p.parseDate(i + 1)
+		if n == 0 {
+			return 0
+		}
+		return n + 1
+	case "next":
+		if p.word(i+1) == "week" {
+			today := p.today()
+			p.date = today.AddDate(0, 0, 7-(int(today.Weekday())+6)%7)
+			p.hasDate = true
+			return 2
+		}
+		day, ok := weekdays[p.word(i+1)]
+		if !ok {
+			return 0
+		}
+		p.date = p.nextWeekday(day)
+		if p.date.Equal(p.today()) {
+			p.date = p.date.AddDate(0, 0, 7)
+		}
+		p.hasDate = true
+		return 2
+	case "in":
+		n, err := strconv.Atoi(p.word(i + 1))
+		if err != nil || n < 1 || n > 999 {
+			return 0
+		}
+		switch p.word(i + 2) {
+		case "day", "days":
+			p.date = p.today().AddDate(0, 0, n)
+		case "week", "weeks":
+			p.date = p.today().AddDate(0, 0, 7*n)
+		default:
+			return 0
+		}
+		p.hasDate = true
+		return 3
+	default:
+		if day, ok := weekdays[w]; ok {
+			p.date = p.nextWeekday(day)
+			break
+		}
+		if !isoDateRegexp.MatchString(w) {
+			return 0
+		}
+		date, err := time.ParseInLocation("2006-01-02", w, p.now.Location())
+		if err != nil {
+			return 0
+		}
+		p.date = date
+	}
+	p.hasDate = true
+	return 1
+}
+
+// the number of words of the time starting at i, 0 if there is none
+func (p *parser) parseTime(i int) int {
+	w := p.word(i)
+	if w == "at" {
+		n := p.parseTime(i + 1)
+		if n == 0 {
+			return 0
+		}
+		return n + 1
+	}
+	if w == "noon" {
+		p.hour, p.minute, p.hasTime = 12, 0, true
+		return 1
+	}
+
+	m := clockRegexp.FindStringSubmatch(w)
+	if m == nil || (m[2] == "" && m[3] == "") {
+		// a number alone is not a time
+		return 0
+	}
+
+	hour, _ := strconv.Atoi(m[1])
+	minute := 0
+	if m[2] != "" {
+		minute, _ = strconv.Atoi(m[2])
+	}
+	if minute > 59 {
+		return 0
+	}
+
+	switch m[3] {
+	case "":
+		if hour > 23 {
+			return 0
+		}
+	case "am", "pm":
+		if hour < 1 || hour > 12 {
+			return 0
+		}
+		hour %= 12
+		if m[3] == "pm" {
+			hour += 12
+		}
+	}
+
+	p.hour, p.minute, p.hasTime = hour, minute, true
+	return 1
+}
+
+// the number of words of "every ..." starting at i, 0 if there is none
+func (p *parser) parseRecurrence(i int) int {
+	if p.word(i) != "every" {
+		return 0
+	}
+
+	w := p.word(i + 1)
+	if freq, ok := frequencies[w]; ok && !strings.HasSuffix(w, "s") {
+		p.result.Recurrence = "FREQ=" + freq
+		return 2
+	}
+	if freq, ok := frequencies[p.word(i+2)]; ok && w == "other" {
+		p.result.Recurrence = "FREQ=" + freq + ";INTERVAL=2"
+		return 3
+	}
+	if w == "weekday" {
+		p.result.Recurrence = "FREQ=WEEKLY;BYDAY=MO,TU,WE,TH,FR"
+		p.everyWeekdays = []time.Weekday{
+			time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday,
+		}
+		return 2
+	}
+	if day, ok := weekdays[strings.TrimSuffix(w, "s")]; ok {
+		p.result.Recurrence = "FREQ=WEEKLY;BYDAY=" + weekdayRuleNames[day]
+		p.everyWeekdays = []time.Weekday{day}
+		return 2
+	}
+
+	n, err := strconv.Atoi(w)
+	if freq, ok := frequencies[p.word(i+2)]; ok && err == nil && n >= 1 && n <= 999 {
+		p.result.Recurrence = "FREQ=" + freq
+		if n > 1 {
+			p.result.Recurrence += ";INTERVAL=" + strconv.Itoa(n)
+		}
+		return 3
+	}
+	return 0
+}
+
+// the number of words of the token starting at i, 0 if it is
+// a word of the description
+func (p *parser) parseToken(i int) int {
+	w := p.words[i]
+	switch {
+	case len(w) > 1 && w[0] == '#':
+		p.result.Labels = append(p.result.Labels, w[1:])
+		return 1
+	case len(w) > 1 && w[0] == '@' && p.result.List == "":
+		p.result.List = w[1:]
+		return 1
+	case len(w) > 1 && w[0] == '!' && p.result.Priority == PriorityNone:
+		if priority, ok := priorities[p.lower[i][1:]]; ok {
+			p.result.Priority = priority
+			return 1
+		}
+		return 0
+	}
+
+	if p.result.Recurrence == "" {
+		if n := p.parseRecurrence(i); n > 0 {
+			return n
+		}
+	}
+	if !p.hasDate {
+		if n := p.parseDate(i); n > 0 {
+			return n
+		}
+	}
+	if !p.hasTime {
+		return p.parseTime(i)
+	}
+	return 0
+}
+
+// Parse a line, relative dates are from now, in its location.
+// A time without date is today, or tomorrow if it is past. A recurrence
+// without date starts today, or on its weekday
+func Parse(text string, now time.Time) Result {
+	words := strings.Fields(text)
+	p := &parser{words: words, now: now}
+	for _, w := range words {
+		p.lower = append(p.lower, strings.ToLower(w))
+	}
+
+	description := make([]string, 0, len(words))
+	for i := 0; i < len(words); {
+		n := p.parseToken(i)
+		if n == 0 {
+			description = append(description, words[i])
+			n = 1
+		}
+		i += n
+	}
+	p.result.Description = strings.Join(description, " ")
+
+	dateGiven := p.hasDate
+	switch {
+	case dateGiven:
+	case len(p.everyWeekdays) > 0:
+		p.date, p.hasDate = nextWeekdayFrom(p.today(), p.everyWeekdays...), true
+	case p.hasTime || p.result.Recurrence != "":
+		p.date, p.hasDate = p.today(), true
+	}
+
+	if p.hasDate && p.hasTime {
+		y, m, d := p.date.Date()
+		p.result.Due = time.Date(y, m, d, p.hour, p.minute, 0, 0, now.Location())
+		// the time is past on the first day of a recurrence or today
+		if !dateGiven && !p.result.Due.After(now) {
+			next := p.result.Due.AddDate(0, 0, 1)
+			if len(p.everyWeekdays) > 0 {
+				next = nextWeekdayFrom(next, p.everyWeekdays...)
+			}
+			p.result.Due = next
+		}
+	} else if p.hasDate {
+		p.result.Due = p.date
+		p.result.AllDay = true
+	}
+	return p.result
+}
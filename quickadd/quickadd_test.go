@@ -0,0 +1,108 @@
+package quickadd
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	// a Wednesday
+	loc := time.FixedZone("UTC+2", 2*60*60)
+	now := time.Date(2026, 3, 11, 15, 30, 0, 0, loc)
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, 3, day, hour, minute, 0, 0, loc)
+	}
+	day := func(day int) time.Time {
+		return at(day, 0, 0)
+	}
+
+	table := []struct {
+		text     string
+		expected Result
+	}{
+		{"Buy milk", Result{Description: "Buy milk"}},
+		{"Pay rent tomorrow 9am #finance !high every month", Result{
+			Description: "Pay rent",
+			Due:         at(12, 9, 0),
+			Labels:      []string{"finance"},
+			Priority:    PriorityHigh,
+			Recurrence:  "FREQ=MONTHLY",
+		}},
+		{"Call mum today", Result{Description: "Call mum", Due: day(11), AllDay: true}},
+		{"Call mum at 5pm", Result{Description: "Call mum", Due: at(11, 17, 0)}},
+		{"Call mum 9:15am", Result{Description: "Call mum", Due: at(12, 9, 15)}},
+		{"Dentist on friday at 14:30 @Health", Result{
+			Description: "Dentist", Due: at(13, 14, 30), List: "Health",
+		}},
+		{"Plan next week", Result{Description: "Plan", Due: day(16), AllDay: true}},
+		{"Review next wednesday", Result{Description: "Review", Due: day(18), AllDay: true}},
+		{"Water plants in 3 days", Result{Description: "Water plants", Due: day(14), AllDay: true}},
+		{"Taxes 2026-04-30 !4 #home #Finance", Result{
+			Description: "Taxes",
+			Due:         time.Date(2026, 4, 30, 0, 0, 0, 0, loc),
+			AllDay:      true,
+			Labels:      []string{"home", "Finance"},
+			Priority:    PriorityUrgent,
+		}},
+		{"Standup every weekday 9am", Result{
+			Description: "Standup",
+			Due:         at(12, 9, 0),
+			Recurrence:  "FREQ=WEEKLY;BYDAY=MO,TU,WE,TH,FR",
+		}},
+		{"Bins every monday", Result{
+			Description: "Bins", Due: day(16), AllDay: true,
+			Recurrence: "FREQ=WEEKLY;BYDAY=MO",
+		}},
+		{"Team lunch every wednesday noon", Result{
+			Description: "Team lunch", Due: at(18, 12, 0),
+			Recurrence: "FREQ=WEEKLY;BYDAY=WE",
+		}},
+		{"Backup every 2 weeks", Result{
+			Description: "Backup", Due: day(11), AllDay: true,
+			Recurrence: "FREQ=WEEKLY;INTERVAL=2",
+		}},
+		{"Stretch every other day", Result{
+			Description: "Stretch", Due: day(11), AllDay: true,
+			Recurrence: "FREQ=DAILY;INTERVAL=2",
+		}},
+		// not recognized, kept in the description
+		{"Read 10 pages !soon on the train at home", Result{
+			Description: "Read 10 pages !soon on the train at home",
+		}},
+		{"Buy 25:00 tickets 13pm", Result{Description: "Buy 25:00 tickets 13pm"}},
+	}
+
+	for _, e := range table {
+		actual := Parse(e.text, now)
+		if !reflect.DeepEqual(actual, e.expected) {
+			t.Errorf("%q:\nexpected %+v\nactual   %+v", e.text, e.expected, actual)
+		}
+	}
+
+	weekdays := "FREQ=WEEKLY;BYDAY=MO,TU,WE,TH,FR"
+	weekend := []struct {
+		text     string
+		now      time.Time
+		expected Result
+	}{
+		// a Saturday
+		{"Standup every weekday", at(14, 10, 0), Result{
+			Description: "Standup", Due: day(16), AllDay: true, Recurrence: weekdays,
+		}},
+		{"Standup every weekday 9am", at(14, 10, 0), Result{
+			Description: "Standup", Due: at(16, 9, 0), Recurrence: weekdays,
+		}},
+		// a Friday after the time
+		{"Standup every weekday 9am", at(13, 10, 0), Result{
+			Description: "Standup", Due: at(16, 9, 0), Recurrence: weekdays,
+		}},
+	}
+
+	for _, e := range weekend {
+		actual := Parse(e.text, e.now)
+		if !reflect.DeepEqual(actual, e.expected) {
+			t.Errorf("%q at %v:\nexpected %+v\nactual   %+v", e.text, e.now, e.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package todo
+
+import "fmt"
+
+// A batch runs the operations of an offline client in order, in one
+// transaction. An operation creating a list or an item can name it with
+// a temporary id that the next operations use instead of its id
+const maxBatchOperations = 100
+
+// what a temporary id names
+type batchKind int
+
+const (
+	batchList batchKind = iota + 1
+	batchItem
+)
+
+type batchID struct {
+	kind batchKind
+	id   int
+}
+
+// ids of the lists and items of the operations by their temporary id
+type batchIDs map[string]batchID
+
+// the id of ref if it is set, id otherwise. ref must name a list or
+// an item as kind tells
+func (ids batchIDs) resolve(kind batchKind, id int, ref string) (int, error) {
+	if ref == "" {
+		return id, nil
+	}
+
+	resolved, ok := ids[ref]
+	if id != 0 || !ok || resolved.kind != kind {
+		return 0, errInvalidInput
+	}
+	return resolved.id, nil
+}
+
+// the list or item of an operation, none for a deletion
+type batchResult struct {
+	list todoList
+	item todoItem
+}
+
+type batchOperation struct {
+	tempID string
+	run    func(ids batchIDs) (batchResult, error)
+}
+
+// batchOperationError tells which operation failed, index is from 0
+type batchOperationError struct {
+	index int
+	err   error
+}
+
+func (e *batchOperationError) Error() string {
+	return fmt.Sprintf("operations[%d]: %v", e.index, e.err)
+}
+
+// run the operations until one fails, its error is a batchOperationError.
+// The caller rolls back the operations already run
+func runBatch(operations []batchOperation) ([]batchResult, error) {
+	if len(operations) == 0 || len(operations) > maxBatchOperations {
+		return nil, errInvalidInput
+	}
+
+	ids := make(batchIDs)
+	results := make([]batchResult, 0, len(operations))
+	for i, op := range operations {
+		if _, ok := ids[op.tempID]; ok && op.tempID != "" {
+			return nil, &batchOperationError{index: i, err: errAlreadyExisted}
+		}
+
+		result, err := op.run(ids)
+		if err != nil {
+			return nil, &batchOperationError{index: i, err: err}
+		}
+
+		if op.tempID != "" {
+			id := batchID{kind: batchItem, id: result.item.id}
+			if result.list.id != 0 {
+				id = batchID{kind: batchList, id: result.list.id}
+			}
+			if id.id == 0 {
+				return nil, &batchOperationError{index: i, err: errInvalidInput}
+			}
+			ids[op.tempID] = id
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
@@ -0,0 +1,83 @@
+package todo
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRunBatch(t *testing.T) {
+	nextID := 100
+	create := func(ids batchIDs) (batchResult, error) {
+		nextID++
+		return batchResult{list: todoList{id: nextID}}, nil
+	}
+	var resolved []int
+	use := func(ref string) func(ids batchIDs) (batchResult, error) {
+		return func(ids batchIDs) (batchResult, error) {
+			id, err := ids.resolve(batchList, 0, ref)
+			resolved = append(resolved, id)
+			return batchResult{item: todoItem{id: 1, todoListID: id}}, err
+		}
+	}
+
+	results, err := runBatch([]batchOperation{
+		{tempID: "a", run: create},
+		{tempID: "b", run: create},
+		{run: use("b")},
+		{run: use("a")},
+	})
+	if err != nil || len(results) != 4 {
+		t.Fatalf("should run the operations, actual: %v %v", results, err)
+	}
+	if len(resolved) != 2 || resolved[0] != 102 || resolved[1] != 101 {
+		t.Errorf("should resolve the temporary ids, actual: %v", resolved)
+	}
+
+	_, err = runBatch([]batchOperation{
+		{tempID: "a", run: create},
+		{run: use("unknown")},
+	})
+	opErr, ok := err.(*batchOperationError)
+	if !ok || opErr.index != 1 || opErr.err != errInvalidInput {
+		t.Errorf("unknown temporary id should fail, actual: %v", err)
+	}
+
+	_, err = runBatch([]batchOperation{
+		{tempID: "a", run: create},
+		{tempID: "a", run: create},
+	})
+	opErr, ok = err.(*batchOperationError)
+	if !ok || opErr.index != 1 || opErr.err != errAlreadyExisted {
+		t.Errorf("duplicate temporary id should fail, actual: %v", err)
+	}
+
+	failure := errors.New("failure")
+	calls := 0
+	_, err = runBatch([]batchOperation{
+		{run: func(ids batchIDs) (batchResult, error) { return batchResult{}, failure }},
+		{run: func(ids batchIDs) (batchResult, error) { calls++; return batchResult{}, nil }},
+	})
+	opErr, ok = err.(*batchOperationError)
+	if !ok || opErr.index != 0 || opErr.err != failure || calls != 0 {
+		t.Errorf("should stop at the first failure, actual: %v %d", err, calls)
+	}
+}
+
+func TestBatchIDsResolve(t *testing.T) {
+	ids := batchIDs{"a": {kind: batchList, id: 5}, "b": {kind: batchItem, id: 6}}
+	if id, err := ids.resolve(batchList, 3, ""); err != nil || id != 3 {
+		t.Errorf("should keep the id, actual: %d %v", id, err)
+	}
+	if _, err := ids.resolve(batchList, 3, "a"); err != errInvalidInput {
+		t.Errorf("id and reference should be invalid, actual: %v", err)
+	}
+	if id, err := ids.resolve(batchItem, 0, "b"); err != nil || id != 6 {
+		t.Errorf("should resolve the item, actual: %d %v", id, err)
+	}
+	if _, err := ids.resolve(batchList, 0, "b"); err != errInvalidInput {
+		t.Errorf("an item should not resolve as a list, actual: %v", err)
+	}
+	if _, err := ids.resolve(batchItem, 0, "a"); err != errInvalidInput {
+		t.Errorf("a list should not resolve as an item, actual: %v", err)
+	}
+}
@@ -0,0 +1,148 @@
+package todo
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Items created at once, from a list of descriptions or a text with
+// one item per line, like a pasted shopping list
+const maxBulkTodoItems = 500
+
+// a line of the text or a description, numbered from 1
+type todoItemLine struct {
+	line        int
+	description string
+	completed   bool
+}
+
+type todoItemLineError struct {
+	line    int
+	message string
+}
+
+// todoItemLinesError tells which lines can't be created,
+// none of the lines are created then
+type todoItemLinesError struct {
+	lines []todoItemLineError
+}
+
+func (e *todoItemLinesError) Error() string {
+	messages := make([]string, 0, len(e.lines))
+	for _, l := range e.lines {
+		messages = append(messages, fmt.Sprintf("line %d: %s", l.line, l.message))
+	}
+	return "invalid lines: " + strings.Join(messages, ", ")
+}
+
+// a Markdown task "- [ ] milk" or "- [x] milk", or a list item "- milk"
+var (
+	todoItemCheckboxRegexp = regexp.MustCompile(`^[-*+]\s+\[([ xX])\]\s*(.*)$`)
+	todoItemBulletRegexp   = regexp.MustCompile(`^[-*+]\s+(.*)$`)
+)
+
+// blank lines are skipped, checkboxes and bullets are removed
+func parseTodoItemText(text string) []todoItemLine {
+	result := make([]todoItemLine, 0)
+	for i, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		item := todoItemLine{line: i + 1, description: line}
+		if m := todoItemCheckboxRegexp.FindStringSubmatch(line); m != nil {
+			item.description = strings.TrimSpace(m[2])
+			item.completed = m[1] != " "
+		} else if m := todoItemBulletRegexp.FindStringSubmatch(line); m != nil {
+			item.description = strings.TrimSpace(m[1])
+		}
+		result = append(result, item)
+	}
+	return result
+}
+
+// either descriptions, taken as is, or text
+func todoItemLines(descriptions []string, text string) ([]todoItemLine, error) {
+	if (len(descriptions) == 0) == (text == "") {
+		return nil, errInvalidInput
+	}
+
+	lines := parseTodoItemText(text)
+	if len(descriptions) > 0 {
+		lines = make([]todoItemLine, 0, len(descriptions))
+		for i, d := range descriptions {
+			lines = append(lines, todoItemLine{line: i + 1, description: d})
+		}
+	}
+
+	if len(lines) == 0 || len(lines) > maxBulkTodoItems {
+		return nil, errInvalidInput
+	}
+	return lines, nil
+}
+
+// append the items at the end of the list, in the order of the lines.
+// Lines checked as done create completed items
+func createTodoItems(
+	accountID, todoListID int, descriptions []string, text string,
+	now time.Time,
+	getter todoListGetter,
+	lastPosition todoItemLastPositionGetter,
+	inserter todoItemsInserter,
+) ([]todoItem, error) {
+	lines, err := todoItemLines(descriptions, text)
+	if err != nil {
+		return nil, err
+	}
+
+	lineErrors := make([]todoItemLineError, 0)
+	for _, l := range lines {
+		if !validateTodoItemDescription(l.description) {
+			lineErrors = append(lineErrors, todoItemLineError{
+				line:    l.line,
+				message: "description must be 4 to 100 bytes long",
+			})
+		}
+	}
+	if len(lineErrors) > 0 {
+		return nil, &todoItemLinesError{lines: lineErrors}
+	}
+
+	todo, err := getter(todoListID)
+	if err != nil {
+		return nil, err
+	}
+
+	if todo.accountID != accountID {
+		return nil, errPermissionDenied
+	}
+
+	last, err := lastPosition(todoListID)
+	if err != nil {
+		return nil, err
+	}
+
+	positions, ok := ranksBetween(last, "", len(lines))
+	if !ok {
+		return nil, errInvalidPosition
+	}
+
+	items := make([]todoItem, 0, len(lines))
+	for i, l := range lines {
+		item := todoItem{
+			todoListID:  todoListID,
+			description: l.description,
+			completed:   l.completed,
+			position:    positions[i],
+		}
+		if l.completed {
+			item.completedAt = now
+			item.completedBy = accountID
+		}
+		items = append(items, item)
+	}
+	return inserter(todoListID, items)
+}
@@ -0,0 +1,66 @@
+package todo
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseTodoItemText(t *testing.T) {
+	text := "Milk\r\n\n- [ ] Eggs\n  - [x] Bread  \n* Butter\n-5 degrees"
+	expected := []todoItemLine{
+		{line: 1, description: "Milk"},
+		{line: 3, description: "Eggs"},
+		{line: 4, description: "Bread", completed: true},
+		{line: 5, description: "Butter"},
+		{line: 6, description: "-5 degrees"},
+	}
+
+	actual := parseTodoItemText(text)
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %+v, actual: %+v", expected, actual)
+	}
+}
+
+func TestCreateTodoItems(t *testing.T) {
+	getter := func(id int) (todoList, error) {
+		return todoList{id: id, accountID: 10}, nil
+	}
+	lastPosition := func(todoListID int) (string, error) {
+		return "m", nil
+	}
+	var inserted []todoItem
+	inserter := func(todoListID int, items []todoItem) ([]todoItem, error) {
+		inserted = items
+		return items, nil
+	}
+	now := time.Now()
+
+	_, err := createTodoItems(10, 1, []string{"Milk"}, "Eggs", now,
+		getter, lastPosition, inserter)
+	if err != errInvalidInput {
+		t.Errorf("descriptions and text should be invalid, actual: %v", err)
+	}
+
+	_, err = createTodoItems(10, 1, nil, "Milk\nEgg\n\n- [x] no", now,
+		getter, lastPosition, inserter)
+	linesErr, ok := err.(*todoItemLinesError)
+	if !ok || len(linesErr.lines) != 2 ||
+		linesErr.lines[0].line != 2 || linesErr.lines[1].line != 4 || inserted != nil {
+		t.Fatalf("should report the invalid lines, actual: %v", err)
+	}
+
+	items, err := createTodoItems(10, 1, nil, "- [ ] Milk\n- [x] Eggs\nBread", now,
+		getter, lastPosition, inserter)
+	if err != nil || len(items) != 3 {
+		t.Fatalf("should create the items, actual: %v %v", items, err)
+	}
+	if items[0].completed || !items[1].completed ||
+		items[1].completedBy != 10 || !items[1].completedAt.Equal(now) {
+		t.Errorf("should complete the checked items, actual: %+v", items)
+	}
+	if !("m" < items[0].position && items[0].position < items[1].position &&
+		items[1].position < items[2].position) {
+		t.Errorf("should append the items in order, actual: %+v", items)
+	}
+}
@@ -0,0 +1,109 @@
+package todo
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math"
+	"strings"
+)
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+	// the size of the single page of a request without page size and
+	// page token, to return everything like before pagination.
+	// One row more is selected to find the next page
+	unpagedPageSize = math.MaxInt32 - 1
+)
+
+var errInvalidPageToken = errors.New("invalid page token")
+
+// page token has a form "payload.signature", both base64 url encoded.
+// payload contains the scope of the query (which list, which ordering)
+// and the sort keys of the last returned row.
+type pageCursor struct {
+	Scope string   `json:"s"`
+	Keys  []string `json:"k"`
+}
+
+type pageTokenCodec struct {
+	secret []byte
+}
+
+func newPageTokenCodec(secret []byte) pageTokenCodec {
+	return pageTokenCodec{secret: secret}
+}
+
+func (c pageTokenCodec) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, c.secret)
+	_, _ = mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+func (c pageTokenCodec) encode(scope string, keys []string) string {
+	payload, err := json.Marshal(pageCursor{Scope: scope, Keys: keys})
+	if err != nil {
+		panic(err)
+	}
+
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString(payload) + "." + enc.EncodeToString(c.sign(payload))
+}
+
+// return nil keys for an empty token (the first page)
+func (c pageTokenCodec) decode(scope string, token string) ([]string, error) {
+	if token == "" {
+		return nil, nil
+	}
+
+	index := strings.Index(token, ".")
+	if index == -1 {
+		return nil, errInvalidPageToken
+	}
+
+	enc := base64.RawURLEncoding
+	payload, err := enc.DecodeString(token[:index])
+	if err != nil {
+		return nil, errInvalidPageToken
+	}
+	signature, err := enc.DecodeString(token[index+1:])
+	if err != nil {
+		return nil, errInvalidPageToken
+	}
+
+	if !hmac.Equal(signature, c.sign(payload)) {
+		return nil, errInvalidPageToken
+	}
+
+	var cursor pageCursor
+	err = json.Unmarshal(payload, &cursor)
+	if err != nil || cursor.Scope != scope || len(cursor.Keys) == 0 {
+		return nil, errInvalidPageToken
+	}
+	return cursor.Keys, nil
+}
+
+func normalizePageSize(pageSize int) (int, error) {
+	if pageSize < 0 {
+		return 0, errInvalidInput
+	}
+	if pageSize == 0 {
+		return defaultPageSize, nil
+	}
+	if pageSize > maxPageSize {
+		return maxPageSize, nil
+	}
+	return pageSize, nil
+}
+
+// a request without page size and page token gets a single page of all
+// the rows, clients written before pagination keep working
+func normalizePageSizeOrAll(pageSize int, pageToken string) (int, error) {
+	if pageSize == 0 && pageToken == "" {
+		return unpagedPageSize, nil
+	}
+	return normalizePageSize(pageSize)
+}
@@ -0,0 +1,77 @@
+package todo
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPageTokenCodec(t *testing.T) {
+	codec := newPageTokenCodec([]byte("secret"))
+
+	keys, err := codec.decode("todo_list:1", "")
+	if err != nil || keys != nil {
+		t.Errorf("empty token should be the first page, actual: %v %v", keys, err)
+	}
+
+	token := codec.encode("todo_list:1", []string{"a", "12"})
+	keys, err = codec.decode("todo_list:1", token)
+	if err != nil || !reflect.DeepEqual(keys, []string{"a", "12"}) {
+		t.Errorf("should decode the keys, actual: %v %v", keys, err)
+	}
+
+	_, err = codec.decode("todo_list:2", token)
+	if err != errInvalidPageToken {
+		t.Errorf("should not be accepted for another scope, actual: %v", err)
+	}
+
+	other := newPageTokenCodec([]byte("other"))
+	_, err = other.decode("todo_list:1", token)
+	if err != errInvalidPageToken {
+		t.Errorf("should not be accepted with another secret, actual: %v", err)
+	}
+
+	tampered := "x" + token[1:]
+	_, err = codec.decode("todo_list:1", tampered)
+	if err != errInvalidPageToken {
+		t.Errorf("tampered token should be rejected, actual: %v", err)
+	}
+
+	_, err = codec.decode("todo_list:1", "abc")
+	if err != errInvalidPageToken {
+		t.Errorf("malformed token should be rejected, actual: %v", err)
+	}
+}
+
+func TestNormalizePageSize(t *testing.T) {
+	size, err := normalizePageSize(0)
+	if err != nil || size != defaultPageSize {
+		t.Errorf("should be the default size, actual: %d", size)
+	}
+
+	size, err = normalizePageSize(5000)
+	if err != nil || size != maxPageSize {
+		t.Errorf("should be the max size, actual: %d", size)
+	}
+
+	_, err = normalizePageSize(-1)
+	if err != errInvalidInput {
+		t.Errorf("should be invalid input, actual: %v", err)
+	}
+}
+
+func TestNormalizePageSizeOrAll(t *testing.T) {
+	size, err := normalizePageSizeOrAll(0, "")
+	if err != nil || size != unpagedPageSize {
+		t.Errorf("should return everything, actual: %d", size)
+	}
+
+	size, err = normalizePageSizeOrAll(0, "token")
+	if err != nil || size != defaultPageSize {
+		t.Errorf("should be the default size, actual: %d", size)
+	}
+
+	size, err = normalizePageSizeOrAll(20, "")
+	if err != nil || size != 20 {
+		t.Errorf("should keep the size, actual: %d", size)
+	}
+}
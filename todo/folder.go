@@ -0,0 +1,150 @@
+package todo
+
+import "time"
+
+// Folder groups todo lists, a folder can be inside
+// another one (parentID) for one level of nesting only
+type folder struct {
+	id        int
+	accountID int
+	parentID  int
+	name      string
+	position  string
+	createdAt time.Time
+	updatedAt time.Time
+}
+
+// parentID is 0 for a top level folder
+type folderSaver = func(accountID, parentID int, name, position string) (int, time.Time, error)
+type folderGetter = func(id int) (folder, error)
+
+// return empty string if the account has no folder
+type folderLastPositionGetter = func(accountID int) (string, error)
+type folderRenamer = func(id int, name string) (time.Time, error)
+
+// the todo lists of the folder are moved out of any folder,
+// its sub folders become top level folders
+type folderDeleter = func(id int) error
+type foldersByAccountSelecter = func(accountID int) ([]folder, error)
+
+// folderID is 0 to move the todo list out of any folder
+type todoListFolderUpdater = func(todoListID, folderID int) (time.Time, error)
+
+func validateFolderName(name string) bool {
+	return len(name) >= 1 && len(name) <= 30
+}
+
+func getOwnedFolder(id, accountID int, getter folderGetter) (folder, error) {
+	f, err := getter(id)
+	if err != nil {
+		return f, err
+	}
+
+	if f.accountID != accountID {
+		return f, errPermissionDenied
+	}
+	return f, nil
+}
+
+func createFolder(
+	accountID, parentID int, name string,
+	getter folderGetter,
+	lastPosition folderLastPositionGetter,
+	saver folderSaver,
+) (folder, error) {
+	if !validateFolderName(name) {
+		return folder{}, errInvalidInput
+	}
+
+	if parentID != 0 {
+		parent, err := getOwnedFolder(parentID, accountID, getter)
+		if err != nil {
+			return folder{}, err
+		}
+		if parent.parentID != 0 {
+			return folder{}, errInvalidInput
+		}
+	}
+
+	last, err := lastPosition(accountID)
+	if err != nil {
+		return folder{}, err
+	}
+
+	position, ok := rankBetween(last, "")
+	if !ok {
+		return folder{}, errInvalidPosition
+	}
+
+	id, createdAt, err := saver(accountID, parentID, name, position)
+	return folder{
+		id:        id,
+		accountID: accountID,
+		parentID:  parentID,
+		name:      name,
+		position:  position,
+		createdAt: createdAt,
+		updatedAt: createdAt,
+	}, err
+}
+
+func renameFolder(
+	id, accountID int, name string,
+	getter folderGetter,
+	renamer folderRenamer,
+) (folder, error) {
+	if !validateFolderName(name) {
+		return folder{}, errInvalidInput
+	}
+
+	f, err := getOwnedFolder(id, accountID, getter)
+	if err != nil {
+		return f, err
+	}
+
+	updatedAt, err := renamer(id, name)
+	f.name = name
+	f.updatedAt = updatedAt
+	return f, err
+}
+
+func deleteFolder(
+	id, accountID int,
+	getter folderGetter,
+	deleter folderDeleter,
+) error {
+	_, err := getOwnedFolder(id, accountID, getter)
+	if err != nil {
+		return err
+	}
+	return deleter(id)
+}
+
+func moveTodoListToFolder(
+	todoListID, accountID, folderID int,
+	listGetter todoListGetter,
+	getter folderGetter,
+	updater todoListFolderUpdater,
+) (todoList, error) {
+	todo, err := listGetter(todoListID)
+	if err != nil {
+		return todo, err
+	}
+
+	if todo.accountID != accountID {
+		return todo, errPermissionDenied
+	}
+
+	if folderID != 0 {
+		_, err := getOwnedFolder(folderID, accountID, getter)
+		if err != nil {
+			return todo, err
+		}
+	}
+
+	updatedAt, err := updater(todoListID, folderID)
+	todo.folderID = folderID
+	todo.version++
+	todo.updatedAt = updatedAt
+	return todo, err
+}
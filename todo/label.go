@@ -0,0 +1,239 @@
+package todo
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Label tags todo items across the todo lists of an account
+type label struct {
+	id        int
+	accountID int
+	name      string
+	color     string
+	createdAt time.Time
+}
+
+type labelSaver = func(accountID int, name, color string) (int, time.Time, error)
+type labelGetter = func(id int) (label, error)
+type labelUpdater = func(id int, name, color string) error
+
+// the label is detached from its items
+type labelDeleter = func(id int) error
+type labelsByAccountSelecter = func(accountID int) ([]label, error)
+
+// labels already attached to the item are ignored
+type todoItemLabelsAttacher = func(itemID int, labelIDs []int) error
+type todoItemLabelDetacher = func(itemID, labelID int) error
+
+// the items with the label across all todo lists,
+// ordered by todo list, position and id
+type labelItemsPageSelecter = func(labelID int, after []string, limit int) ([]todoItem, error)
+
+var labelColorRegexp = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+func validateLabelName(name string) bool {
+	return len(name) >= 1 && len(name) <= 30
+}
+
+// empty for no colour, "#rrggbb" otherwise
+func validateLabelColor(color string) bool {
+	return color == "" || labelColorRegexp.MatchString(color)
+}
+
+// names are unique per account, ignoring case
+func labelNameTaken(labels []label, name string, exceptID int) bool {
+	for _, l := range labels {
+		if l.id != exceptID && strings.EqualFold(l.name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func getOwnedLabel(id, accountID int, getter labelGetter) (label, error) {
+	l, err := getter(id)
+	if err != nil {
+		return l, err
+	}
+
+	if l.accountID != accountID {
+		return l, errPermissionDenied
+	}
+	return l, nil
+}
+
+func createLabel(
+	accountID int, name, color string,
+	selecter labelsByAccountSelecter,
+	saver labelSaver,
+) (label, error) {
+	if !validateLabelName(name) || !validateLabelColor(color) {
+		return label{}, errInvalidInput
+	}
+
+	labels, err := selecter(accountID)
+	if err != nil {
+		return label{}, err
+	}
+	if labelNameTaken(labels, name, 0) {
+		return label{}, errAlreadyExisted
+	}
+
+	id, createdAt, err := saver(accountID, name, color)
+	return label{
+		id:        id,
+		accountID: accountID,
+		name:      name,
+		color:     color,
+		createdAt: createdAt,
+	}, err
+}
+
+func updateLabel(
+	id, accountID int, name, color string,
+	getter labelGetter,
+	selecter labelsByAccountSelecter,
+	updater labelUpdater,
+) (label, error) {
+	if !validateLabelName(name) || !validateLabelColor(color) {
+		return label{}, errInvalidInput
+	}
+
+	l, err := getOwnedLabel(id, accountID, getter)
+	if err != nil {
+		return l, err
+	}
+
+	labels, err := selecter(accountID)
+	if err != nil {
+		return l, err
+	}
+	if labelNameTaken(labels, name, id) {
+		return l, errAlreadyExisted
+	}
+
+	l.name = name
+	l.color = color
+	return l, updater(id, name, color)
+}
+
+func deleteLabel(
+	id, accountID int,
+	getter labelGetter,
+	deleter labelDeleter,
+) error {
+	_, err := getOwnedLabel(id, accountID, getter)
+	if err != nil {
+		return err
+	}
+	return deleter(id)
+}
+
+// the account must own both the item and the labels
+func attachTodoItemLabels(
+	itemID, accountID int, labelIDs []int,
+	listGetter todoListGetter,
+	getter todoItemGetter,
+	labelGetter labelGetter,
+	attacher todoItemLabelsAttacher,
+) (todoItem, error) {
+	if len(labelIDs) == 0 {
+		return todoItem{}, errInvalidInput
+	}
+
+	item, err := getOwnedTodoItem(itemID, accountID, listGetter, getter)
+	if err != nil {
+		return item, err
+	}
+
+	for _, id := range labelIDs {
+		_, err := getOwnedLabel(id, accountID, labelGetter)
+		if err != nil {
+			return item, err
+		}
+	}
+
+	err = attacher(itemID, labelIDs)
+	if err != nil {
+		return item, err
+	}
+
+	attached := make(map[int]bool)
+	for _, id := range item.labelIDs {
+		attached[id] = true
+	}
+	for _, id := range labelIDs {
+		if !attached[id] {
+			attached[id] = true
+			item.labelIDs = append(item.labelIDs, id)
+		}
+	}
+	return item, nil
+}
+
+func detachTodoItemLabel(
+	itemID, accountID, labelID int,
+	listGetter todoListGetter,
+	getter todoItemGetter,
+	detacher todoItemLabelDetacher,
+) (todoItem, error) {
+	item, err := getOwnedTodoItem(itemID, accountID, listGetter, getter)
+	if err != nil {
+		return item, err
+	}
+
+	labelIDs := make([]int, 0, len(item.labelIDs))
+	for _, id := range item.labelIDs {
+		if id != labelID {
+			labelIDs = append(labelIDs, id)
+		}
+	}
+	item.labelIDs = labelIDs
+	return item, detacher(itemID, labelID)
+}
+
+func labelItemsPageKeys(item todoItem) []string {
+	return []string{
+		strconv.Itoa(item.todoListID), item.position, strconv.Itoa(item.id),
+	}
+}
+
+// return the items with the label and the token of the next page
+func selectLabelItemsPage(
+	labelID, accountID, pageSize int, pageToken string,
+	codec pageTokenCodec,
+	getter labelGetter,
+	selecter labelItemsPageSelecter,
+) ([]todoItem, string, error) {
+	size, err := normalizePageSize(pageSize)
+	if err != nil {
+		return nil, "", err
+	}
+
+	_, err = getOwnedLabel(labelID, accountID, getter)
+	if err != nil {
+		return nil, "", err
+	}
+
+	scope := fmt.Sprintf("label_item:%d", labelID)
+	after, err := codec.decode(scope, pageToken)
+	if err != nil {
+		return nil, "", err
+	}
+
+	items, err := selecter(labelID, after, size+1)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if len(items) <= size {
+		return items, "", nil
+	}
+
+	items = items[:size]
+	return items, codec.encode(scope, labelItemsPageKeys(items[size-1])), nil
+}
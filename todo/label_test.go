@@ -0,0 +1,66 @@
+package todo
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCreateLabel(t *testing.T) {
+	selecter := func(accountID int) ([]label, error) {
+		return []label{{id: 1, accountID: accountID, name: "Home"}}, nil
+	}
+	saveCount := 0
+	saver := func(accountID int, name, color string) (int, time.Time, error) {
+		saveCount++
+		return 2, time.Now(), nil
+	}
+
+	_, err := createLabel(10, "home", "", selecter, saver)
+	if err != errAlreadyExisted || saveCount != 0 {
+		t.Errorf("should be already existed ignoring case, actual: %v", err)
+	}
+
+	_, err = createLabel(10, "work", "red", selecter, saver)
+	if err != errInvalidInput || saveCount != 0 {
+		t.Errorf("should be invalid colour, actual: %v", err)
+	}
+
+	l, err := createLabel(10, "work", "#FF8800", selecter, saver)
+	if err != nil || saveCount != 1 || l.id != 2 || l.color != "#FF8800" {
+		t.Errorf("should be created, actual: %+v %v", l, err)
+	}
+}
+
+func TestAttachTodoItemLabels(t *testing.T) {
+	listGetter := func(id int) (todoList, error) {
+		return todoList{id: id, accountID: 10}, nil
+	}
+	getter := func(id int) (todoItem, error) {
+		return todoItem{id: id, todoListID: 1, labelIDs: []int{1}}, nil
+	}
+	labelGetter := func(id int) (label, error) {
+		accountID := 10
+		if id == 3 {
+			accountID = 11
+		}
+		return label{id: id, accountID: accountID}, nil
+	}
+	var attached []int
+	attacher := func(itemID int, labelIDs []int) error {
+		attached = labelIDs
+		return nil
+	}
+
+	_, err := attachTodoItemLabels(5, 10, []int{2, 3},
+		listGetter, getter, labelGetter, attacher)
+	if err != errPermissionDenied || attached != nil {
+		t.Errorf("should not attach a label of another account, actual: %v", err)
+	}
+
+	item, err := attachTodoItemLabels(5, 10, []int{1, 2},
+		listGetter, getter, labelGetter, attacher)
+	if err != nil || len(attached) != 2 || len(item.labelIDs) != 2 ||
+		item.labelIDs[0] != 1 || item.labelIDs[1] != 2 {
+		t.Errorf("should attach the new label once, actual: %v %v", item.labelIDs, err)
+	}
+}
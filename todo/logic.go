@@ -2,8 +2,11 @@ package todo
 
 import (
 	"errors"
+	"fmt"
 	"regexp"
+	"strconv"
 	"time"
+	"unicode/utf8"
 
 	"github.com/golang/glog"
 	"golang.org/x/crypto/bcrypt"
@@ -12,6 +15,9 @@ import (
 var errAlreadyExisted error = errors.New("already existed")
 var errInvalidInput error = errors.New("invalid input")
 var errPermissionDenied error = errors.New("permission denied")
+var errInvalidPosition error = errors.New("invalid position")
+var errTodoListNotEmpty error = errors.New("todo list is not empty")
+var errVersionMismatch error = errors.New("version mismatch")
 
 // error can be errAlreadyExisted
 // passwordHash should use bcrypt
@@ -55,14 +61,29 @@ type todoList struct {
 	id        int
 	accountID int
 	name      string
+	position  string
+	folderID  int
+	version   int
 	createdAt time.Time
 	updatedAt time.Time
 }
 
-type todoListSaver = func(accountID int, name string) (int, time.Time, error)
+type todoListSaver = func(accountID int, name string, position string) (int, time.Time, error)
 type todoListGetter = func(id int) (todoList, error)
 type todoListUpdater = func(id int, name string) (time.Time, error)
 
+// return empty string if the account has no todo list
+type todoListLastPositionGetter = func(accountID int) (string, error)
+
+// return the position of the closest todo list after (or before) position,
+// empty string if there is none
+type todoListNeighbourGetter = func(accountID int, position string, after bool) (string, error)
+type todoListPositionUpdater = func(id int, position string) (time.Time, error)
+
+func validateTodoItemDescription(description string) bool {
+	return len(description) >= 4 && len(description) <= 100
+}
+
 func validateTodoListName(name string) bool {
 	if len(name) < 5 || len(name) > 30 {
 		return false
@@ -72,23 +93,40 @@ func validateTodoListName(name string) bool {
 
 func createTodoList(
 	accountID int, name string,
+	lastPosition todoListLastPositionGetter,
 	saver todoListSaver,
 ) (todoList, error) {
-	if validateTodoListName(name) {
-		id, createdAt, err := saver(accountID, name)
-		return todoList{
-			id:        id,
-			name:      name,
-			accountID: accountID,
-			createdAt: createdAt,
-			updatedAt: createdAt,
-		}, err
+	if !validateTodoListName(name) {
+		return todoList{}, errInvalidInput
+	}
+
+	last, err := lastPosition(accountID)
+	if err != nil {
+		return todoList{}, err
 	}
-	return todoList{}, errInvalidInput
+
+	position, ok := rankBetween(last, "")
+	if !ok {
+		return todoList{}, errInvalidPosition
+	}
+
+	id, createdAt, err := saver(accountID, name, position)
+	return todoList{
+		id:        id,
+		name:      name,
+		accountID: accountID,
+		position:  position,
+		version:   1,
+		createdAt: createdAt,
+		updatedAt: createdAt,
+	}, err
 }
 
+// expectedVersion is 0 to update unconditionally, otherwise
+// errVersionMismatch is returned with the current todo list if it is stale
 func updateTodoList(
 	id, accountID int, name string,
+	expectedVersion int,
 	getter todoListGetter,
 	updater todoListUpdater,
 ) (todoList, error) {
@@ -101,56 +139,342 @@ func updateTodoList(
 		return todo, errPermissionDenied
 	}
 
+	if expectedVersion != 0 && todo.version != expectedVersion {
+		return todo, errVersionMismatch
+	}
+
 	updatedAt, err := updater(id, name)
 	todo.name = name
+	todo.version++
 	todo.updatedAt = updatedAt
 	return todo, err
 }
 
-type todoListsByAccountGetter = func(accountID int) ([]todoList, error)
+type todoListStats struct {
+	activeCount    int
+	completedCount int
+	lastActivityAt time.Time
+}
+
+type todoListStatsGetter = func(todoListID int) (todoListStats, error)
+
+func getTodoListWithStats(
+	id, accountID int,
+	getter todoListGetter,
+	statsGetter todoListStatsGetter,
+) (todoList, todoListStats, error) {
+	todo, err := getter(id)
+	if err != nil {
+		return todo, todoListStats{}, err
+	}
+
+	if todo.accountID != accountID {
+		return todoList{}, todoListStats{}, errPermissionDenied
+	}
+
+	stats, err := statsGetter(id)
+	return todo, stats, err
+}
+
+// exactly one of afterID, beforeID must be non zero
+func moveTodoList(
+	id, accountID, afterID, beforeID int,
+	getter todoListGetter,
+	neighbour todoListNeighbourGetter,
+	updater todoListPositionUpdater,
+) (todoList, error) {
+	if (afterID == 0) == (beforeID == 0) {
+		return todoList{}, errInvalidInput
+	}
+
+	todo, err := getter(id)
+	if err != nil {
+		return todo, err
+	}
+
+	if todo.accountID != accountID {
+		return todo, errPermissionDenied
+	}
+
+	after := afterID != 0
+	siblingID := afterID
+	if !after {
+		siblingID = beforeID
+	}
+	if siblingID == id {
+		return todo, errInvalidInput
+	}
+
+	sibling, err := getter(siblingID)
+	if err != nil {
+		return todo, err
+	}
+
+	if sibling.accountID != accountID {
+		return todo, errPermissionDenied
+	}
+
+	other, err := neighbour(accountID, sibling.position, after)
+	if err != nil {
+		return todo, err
+	}
+
+	var position string
+	var ok bool
+	if after {
+		position, ok = rankBetween(sibling.position, other)
+	} else {
+		position, ok = rankBetween(other, sibling.position)
+	}
+	if !ok {
+		return todo, errInvalidPosition
+	}
+
+	updatedAt, err := updater(id, position)
+	todo.position = position
+	todo.version++
+	todo.updatedAt = updatedAt
+	return todo, err
+}
+
+// after contains the sort keys of the last todo list of the previous page,
+// nil for the first page. At most limit todo lists are returned
+type todoListPageSelecter = func(accountID int, after []string, limit int) ([]todoList, error)
 type todoListDeleter = func(id int) error
 
+// return the number of items of the todo list
+type todoListItemCounter = func(todoListID int) (int, error)
+
+// return the number of deleted items
+type todoListItemsDeleter = func(todoListID int) (int, error)
+
+// delete the todo list together with its items,
+// return errTodoListNotEmpty if requireEmpty and the list has items
 func deleteTodoList(
 	id, accountID int,
+	requireEmpty bool,
 	getter todoListGetter,
+	counter todoListItemCounter,
+	itemsDeleter todoListItemsDeleter,
 	deleter todoListDeleter,
-) error {
+) (int, error) {
 	todo, err := getter(id)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	if todo.accountID != accountID {
-		return errPermissionDenied
+		return 0, errPermissionDenied
 	}
 
-	return deleter(id)
+	if requireEmpty {
+		count, err := counter(id)
+		if err != nil {
+			return 0, err
+		}
+		if count > 0 {
+			return 0, errTodoListNotEmpty
+		}
+	}
+
+	deleted, err := itemsDeleter(id)
+	if err != nil {
+		return 0, err
+	}
+
+	return deleted, deleter(id)
 }
 
+func todoListPageKeys(todo todoList) []string {
+	return []string{todo.position, strconv.Itoa(todo.id)}
+}
+
+// return the todo lists of the page and the token of the next page,
+// empty if it is the last page
+func selectTodoListsPage(
+	accountID, pageSize int, pageToken string,
+	codec pageTokenCodec,
+	selecter todoListPageSelecter,
+) ([]todoList, string, error) {
+	size, err := normalizePageSizeOrAll(pageSize, pageToken)
+	if err != nil {
+		return nil, "", err
+	}
+
+	scope := fmt.Sprintf("todo_list:%d", accountID)
+	after, err := codec.decode(scope, pageToken)
+	if err != nil {
+		return nil, "", err
+	}
+
+	todos, err := selecter(accountID, after, size+1)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if len(todos) <= size {
+		return todos, "", nil
+	}
+
+	todos = todos[:size]
+	return todos, codec.encode(scope, todoListPageKeys(todos[size-1])), nil
+}
+
+// dueAt and remindAt are zero when not set,
+// dueAllDay means only the date of dueAt (in UTC) matters
 type todoItem struct {
 	id          int
 	todoListID  int
 	description string
 	completed   bool
+	position    string
+	version     int
+	dueAt       time.Time
+	dueAllDay   bool
+	remindAt    time.Time
+	recurrence  string
+	priority    int
+	labelIDs    []int
+	// 0 for a top level item
+	parentID int
+	// zero values while the item is not completed
+	completedAt time.Time
+	completedBy int
 	createdAt   time.Time
+	updatedAt   time.Time
 }
 
-type todoItemSaver = func(todoListID int, description string) (int, time.Time, error)
+const (
+	priorityNone = iota
+	priorityLow
+	priorityMedium
+	priorityHigh
+	priorityUrgent
+)
+
+func validateTodoItemPriority(priority int) bool {
+	return priority >= priorityNone && priority <= priorityUrgent
+}
+
+type todoItemGetter = func(id int) (todoItem, error)
+
+// insert the items into the todo list keeping their fields and labels,
+// return the inserted items with their ids
+type todoItemsInserter = func(todoListID int, items []todoItem) ([]todoItem, error)
+
+// return empty string if the todo list has no item
+type todoItemLastPositionGetter = func(todoListID int) (string, error)
+
+// return the position of the closest item after (or before) position
+// in the same todo list, empty string if there is none
+type todoItemNeighbourGetter = func(todoListID int, position string, after bool) (string, error)
+type todoItemPositionUpdater = func(id int, position string) (time.Time, error)
 type todoItemSelecter = func(todoListID int) ([]todoItem, error)
-type todoItemsCompletedUpdater = func(toBeCompleted []int, toBeActive []int) error
-type todoItemsCompletedDeleter = func(todoListID int) error
 
+// after contains the sort keys of the last item of the previous page,
+// nil for the first page. At most limit items are returned
+type todoItemPageSelecter = func(query todoItemQuery, after []string, limit int) ([]todoItem, error)
+
+// items becoming completed record when and by which account
+type todoItemsCompletedUpdater = func(toBeCompleted []int, toBeActive []int, accountID int) error
+
+// only the fields whose set flag is true are updated
+type todoItemChanges struct {
+	setDescription bool
+	description    string
+	setCompleted   bool
+	completed      bool
+	setDueAt       bool
+	dueAt          time.Time
+	dueAllDay      bool
+	setRemindAt    bool
+	remindAt       time.Time
+	setRecurrence  bool
+	recurrence     string
+	setPriority    bool
+	priority       int
+	// the account completing the item when setCompleted
+	completedBy int
+}
+
+type todoItemUpdater = func(id int, changes todoItemChanges) (time.Time, error)
+type todoItemDeleter = func(id int) error
+type todoItemsDeleter = func(ids []int) error
+
+const dueDateLayout = "2006-01-02"
+
+// due date has a form "2006-01-02" for a whole day,
+// or RFC 3339 with a timezone "2006-01-02T15:04:05+07:00".
+// Empty string means no due date
+func parseDueAt(s string) (time.Time, bool, error) {
+	if s == "" {
+		return time.Time{}, false, nil
+	}
+
+	t, err := time.Parse(dueDateLayout, s)
+	if err == nil {
+		return t, true, nil
+	}
+
+	t, err = time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, false, errInvalidInput
+	}
+	return t.UTC(), false, nil
+}
+
+func formatDueAt(t time.Time, allDay bool) string {
+	if t.IsZero() {
+		return ""
+	}
+	if allDay {
+		return t.Format(dueDateLayout)
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func validateScheduleTime(t time.Time) bool {
+	return t.IsZero() || (t.Year() >= 1970 && t.Year() <= 9999)
+}
+
+// a reminder can't be after a due time, but can be on the day of a due date
+func validateTodoItemSchedule(item todoItem) bool {
+	if !validateScheduleTime(item.dueAt) || !validateScheduleTime(item.remindAt) {
+		return false
+	}
+
+	// occurrences are computed from the due date
+	if item.dueAt.IsZero() {
+		return !item.dueAllDay && item.recurrence == ""
+	}
+
+	if !item.remindAt.IsZero() {
+		dueEnd := item.dueAt
+		if item.dueAllDay {
+			dueEnd = dueEnd.AddDate(0, 0, 1)
+		}
+		if item.remindAt.After(dueEnd) {
+			return false
+		}
+	}
+	return true
+}
+
+// item contains the todo list id, description and the schedule
 func createTodoItem(
-	todoListID, accountID int,
-	description string,
+	accountID int, item todoItem,
 	getter todoListGetter,
-	saver todoItemSaver,
+	itemGetter todoItemGetter,
+	lastPosition todoItemLastPositionGetter,
+	inserter todoItemsInserter,
 ) (todoItem, error) {
-	if len(description) < 4 || len(description) > 100 {
+	if !validateTodoItemDescription(item.description) ||
+		!validateTodoItemPriority(item.priority) ||
+		!validateTodoItemSchedule(item) {
 		return todoItem{}, errInvalidInput
 	}
 
-	todo, err := getter(todoListID)
+	todo, err := getter(item.todoListID)
 	if err != nil {
 		return todoItem{}, err
 	}
@@ -159,34 +483,275 @@ func createTodoItem(
 		return todoItem{}, errPermissionDenied
 	}
 
-	id, t, err := saver(todoListID, description)
+	if item.parentID != 0 {
+		err := validateSubtaskParent(item.parentID, item.todoListID, itemGetter)
+		if err != nil {
+			return todoItem{}, err
+		}
+	}
 
-	return todoItem{
-		id:          id,
-		todoListID:  todoListID,
-		description: description,
-		completed:   false,
-		createdAt:   t,
-	}, err
+	last, err := lastPosition(item.todoListID)
+	if err != nil {
+		return todoItem{}, err
+	}
+
+	position, ok := rankBetween(last, "")
+	if !ok {
+		return todoItem{}, errInvalidPosition
+	}
+
+	item.completed = false
+	item.position = position
+
+	inserted, err := inserter(item.todoListID, []todoItem{item})
+	if err != nil {
+		return todoItem{}, err
+	}
+	return inserted[0], nil
 }
 
-func selectTodoItems(
-	todoListID, accountID int,
+// exactly one of afterID, beforeID must be non zero,
+// the sibling must belong to the same todo list
+func moveTodoItem(
+	id, accountID, afterID, beforeID int,
+	listGetter todoListGetter,
+	getter todoItemGetter,
+	neighbour todoItemNeighbourGetter,
+	updater todoItemPositionUpdater,
+) (todoItem, error) {
+	if (afterID == 0) == (beforeID == 0) {
+		return todoItem{}, errInvalidInput
+	}
+
+	item, err := getter(id)
+	if err != nil {
+		return item, err
+	}
+
+	todo, err := listGetter(item.todoListID)
+	if err != nil {
+		return item, err
+	}
+
+	if todo.accountID != accountID {
+		return item, errPermissionDenied
+	}
+
+	after := afterID != 0
+	siblingID := afterID
+	if !after {
+		siblingID = beforeID
+	}
+	if siblingID == id {
+		return item, errInvalidInput
+	}
+
+	sibling, err := getter(siblingID)
+	if err != nil {
+		return item, err
+	}
+
+	if sibling.todoListID != item.todoListID {
+		return item, errInvalidInput
+	}
+
+	other, err := neighbour(item.todoListID, sibling.position, after)
+	if err != nil {
+		return item, err
+	}
+
+	var position string
+	var ok bool
+	if after {
+		position, ok = rankBetween(sibling.position, other)
+	} else {
+		position, ok = rankBetween(other, sibling.position)
+	}
+	if !ok {
+		return item, errInvalidPosition
+	}
+
+	updatedAt, err := updater(id, position)
+	item.position = position
+	item.version++
+	item.updatedAt = updatedAt
+	return item, err
+}
+
+type todoItemSort int
+
+const (
+	sortByPosition todoItemSort = iota
+	sortByDueAt
+	sortByCreatedAt
+	// the most urgent first
+	sortByPriority
+	// uncompleted items first, then by position
+	sortByCompletedLast
+)
+
+// items without due date are sorted after the others
+var noDueAtSortKey = time.Date(9999, 12, 31, 23, 59, 59, 0, time.UTC)
+
+const sortKeyTimeLayout = "2006-01-02 15:04:05"
+
+// filters of the items of a todo list, zero values mean no filter.
+// overdueAt selects the items not completed with a due time before it,
+// or a due date before its day in its location, the account timezone
+type todoItemQuery struct {
+	todoListID int
+	sortBy     todoItemSort
+	dueAfter   time.Time
+	dueBefore  time.Time
+	overdueAt  time.Time
+	// items with all these labels
+	labelIDs []int
+	status   todoItemStatus
+	// substring of the description
+	text          string
+	createdAfter  time.Time
+	createdBefore time.Time
+}
+
+type todoItemStatus int
+
+const (
+	statusAll todoItemStatus = iota
+	statusActive
+	statusCompleted
+)
+
+func validateTodoItemSort(sortBy todoItemSort) bool {
+	return sortBy >= sortByPosition && sortBy <= sortByCompletedLast
+}
+
+func validateTodoItemQuery(query todoItemQuery) bool {
+	return validateTodoItemSort(query.sortBy) &&
+		query.status >= statusAll && query.status <= statusCompleted &&
+		len(query.text) <= 100
+}
+
+// must match the ORDER BY of the repository for the sort
+func todoItemPageKeys(item todoItem, sortBy todoItemSort) []string {
+	keys := []string{item.position, strconv.Itoa(item.id)}
+	switch sortBy {
+	case sortByDueAt:
+		due := item.dueAt
+		if due.IsZero() {
+			due = noDueAtSortKey
+		}
+		keys = append([]string{due.UTC().Format(sortKeyTimeLayout)}, keys...)
+	case sortByCreatedAt:
+		keys = []string{item.createdAt.UTC().Format(sortKeyTimeLayout), strconv.Itoa(item.id)}
+	case sortByPriority:
+		keys = append([]string{strconv.Itoa(priorityUrgent - item.priority)}, keys...)
+	case sortByCompletedLast:
+		completed := "0"
+		if item.completed {
+			completed = "1"
+		}
+		keys = append([]string{completed}, keys...)
+	}
+	return keys
+}
+
+// return the items of the page and the token of the next page,
+// empty if it is the last page
+func selectTodoItemsPage(
+	query todoItemQuery, accountID, pageSize int, pageToken string,
+	codec pageTokenCodec,
 	getter todoListGetter,
-	selecter todoItemSelecter,
-) ([]todoItem, error) {
-	result := make([]todoItem, 0)
+	selecter todoItemPageSelecter,
+) ([]todoItem, string, error) {
+	if !validateTodoItemQuery(query) {
+		return nil, "", errInvalidInput
+	}
+
+	size, err := normalizePageSizeOrAll(pageSize, pageToken)
+	if err != nil {
+		return nil, "", err
+	}
 
+	todoListID := query.todoListID
 	todo, err := getter(todoListID)
 	if err != nil {
-		return result, err
+		return nil, "", err
+	}
+
+	if todo.accountID != accountID {
+		return nil, "", errPermissionDenied
+	}
+
+	scope := fmt.Sprintf("todo_item:%d:%d", todoListID, query.sortBy)
+	after, err := codec.decode(scope, pageToken)
+	if err != nil {
+		return nil, "", err
+	}
+
+	items, err := selecter(query, after, size+1)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if len(items) <= size {
+		return items, "", nil
+	}
+
+	items = items[:size]
+	keys := todoItemPageKeys(items[size-1], query.sortBy)
+	return items, codec.encode(scope, keys), nil
+}
+
+// copy the todo list and its items into a new list named name,
+// at the end of the account's lists. Completed items are skipped
+// unless includeCompleted, and become active again if resetCompleted
+func duplicateTodoList(
+	id, accountID int, name string,
+	includeCompleted, resetCompleted bool,
+	getter todoListGetter,
+	selecter todoItemSelecter,
+	lastPosition todoListLastPositionGetter,
+	saver todoListSaver,
+	inserter todoItemsInserter,
+) (todoList, int, error) {
+	if !validateTodoListName(name) {
+		return todoList{}, 0, errInvalidInput
+	}
+
+	todo, err := getter(id)
+	if err != nil {
+		return todoList{}, 0, err
 	}
 
 	if todo.accountID != accountID {
-		return result, errPermissionDenied
+		return todoList{}, 0, errPermissionDenied
+	}
+
+	items, err := selecter(id)
+	if err != nil {
+		return todoList{}, 0, err
+	}
+
+	copies := make([]todoItem, 0, len(items))
+	for _, item := range items {
+		if item.completed && !includeCompleted {
+			continue
+		}
+		if resetCompleted {
+			item.completed = false
+			item.completedAt = time.Time{}
+			item.completedBy = 0
+		}
+		copies = append(copies, item)
+	}
+
+	newTodo, err := createTodoList(accountID, name, lastPosition, saver)
+	if err != nil {
+		return newTodo, 0, err
 	}
 
-	return selecter(todoListID)
+	inserted, err := insertTodoItemTree(newTodo.id, copies, inserter)
+	return newTodo, len(inserted), err
 }
 
 func todoItemsContain(items []todoItem, ids []int) bool {
@@ -203,38 +768,444 @@ func todoItemsContain(items []todoItem, ids []int) bool {
 	return true
 }
 
+// return the items whose version differs from expectedVersions
+func staleTodoItems(items []todoItem, expectedVersions map[int]int) []todoItem {
+	result := make([]todoItem, 0)
+	for _, item := range items {
+		version, ok := expectedVersions[item.id]
+		if ok && version != item.version {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// the next occurrences of the recurring items becoming completed
+func nextOccurrences(items []todoItem, toBeCompleted []int, loc *time.Location) []todoItem {
+	completing := make(map[int]bool)
+	for _, id := range toBeCompleted {
+		completing[id] = true
+	}
+
+	result := make([]todoItem, 0)
+	for _, item := range items {
+		if !completing[item.id] || item.completed {
+			continue
+		}
+		if next, ok := nextOccurrence(item, loc); ok {
+			result = append(result, next)
+		}
+	}
+	return result
+}
+
+// expectedVersions maps item ids to their expected version,
+// if one is stale errVersionMismatch is returned with the stale items.
+// With completeSubtasks the subtasks of the completed items are
+// completed too. Completing a recurring item creates its next occurrence
+// at the end of the todo list, the created items are returned.
+// loc is the timezone of the account
 func updateTodoItemsCompleted(
 	todoListID, accountID int,
 	toBeCompleted, toBeActive []int,
+	completeSubtasks bool,
+	expectedVersions map[int]int,
+	loc *time.Location,
 	getter todoListGetter,
 	selecter todoItemSelecter,
 	updater todoItemsCompletedUpdater,
-) error {
+	lastPosition todoItemLastPositionGetter,
+	inserter todoItemsInserter,
+) ([]todoItem, []todoItem, error) {
 	todo, err := getter(todoListID)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	if todo.accountID != accountID {
-		return errPermissionDenied
+		return nil, nil, errPermissionDenied
 	}
 
 	items, err := selecter(todoListID)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 	if !todoItemsContain(items, toBeCompleted) ||
 		!todoItemsContain(items, toBeActive) {
-		return errPermissionDenied
+		return nil, nil, errPermissionDenied
+	}
+
+	stale := staleTodoItems(items, expectedVersions)
+	if len(stale) > 0 {
+		return nil, stale, errVersionMismatch
 	}
 
-	return updater(toBeCompleted, toBeActive)
+	if completeSubtasks {
+		toBeCompleted = withUncompletedSubtasks(items, toBeCompleted)
+	}
+
+	err = updater(toBeCompleted, toBeActive, accountID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	next := nextOccurrences(items, toBeCompleted, loc)
+	if len(next) == 0 {
+		return nil, nil, nil
+	}
+
+	last, err := lastPosition(todoListID)
+	if err != nil {
+		return nil, nil, err
+	}
+	positions, ok := ranksBetween(last, "", len(next))
+	if !ok {
+		return nil, nil, errInvalidPosition
+	}
+	for i := range next {
+		next[i].position = positions[i]
+	}
+
+	created, err := inserter(todoListID, next)
+	return created, nil, err
+}
+
+// return the item if the account owns its todo list
+func getOwnedTodoItem(
+	id, accountID int,
+	listGetter todoListGetter,
+	getter todoItemGetter,
+) (todoItem, error) {
+	item, err := getter(id)
+	if err != nil {
+		return item, err
+	}
+
+	todo, err := listGetter(item.todoListID)
+	if err != nil {
+		return item, err
+	}
+
+	if todo.accountID != accountID {
+		return item, errPermissionDenied
+	}
+	return item, nil
 }
 
+// expectedVersion is 0 to update unconditionally, otherwise
+// errVersionMismatch is returned with the current item if it is stale.
+// Completing a recurring item creates its next occurrence at the end of
+// the todo list like updateTodoItemsCompleted, the created items are returned
+func updateTodoItem(
+	id, accountID int,
+	changes todoItemChanges,
+	expectedVersion int,
+	loc *time.Location,
+	listGetter todoListGetter,
+	getter todoItemGetter,
+	updater todoItemUpdater,
+	lastPosition todoItemLastPositionGetter,
+	inserter todoItemsInserter,
+) (todoItem, []todoItem, error) {
+	if (changes.setDescription && !validateTodoItemDescription(changes.description)) ||
+		(changes.setPriority && !validateTodoItemPriority(changes.priority)) {
+		return todoItem{}, nil, errInvalidInput
+	}
+
+	item, err := getOwnedTodoItem(id, accountID, listGetter, getter)
+	if err != nil {
+		return item, nil, err
+	}
+
+	if expectedVersion != 0 && item.version != expectedVersion {
+		return item, nil, errVersionMismatch
+	}
+
+	updated := item
+	if changes.setDescription {
+		updated.description = changes.description
+	}
+	if changes.setCompleted {
+		updated.completed = changes.completed
+	}
+	if changes.setDueAt {
+		updated.dueAt = changes.dueAt
+		updated.dueAllDay = changes.dueAllDay
+	}
+	if changes.setRemindAt {
+		updated.remindAt = changes.remindAt
+	}
+	if changes.setRecurrence {
+		updated.recurrence = changes.recurrence
+	}
+	if changes.setPriority {
+		updated.priority = changes.priority
+	}
+	if !validateTodoItemSchedule(updated) {
+		return item, nil, errInvalidInput
+	}
+
+	var next []todoItem
+	if changes.setCompleted && changes.completed {
+		// the occurrence of the updated rule and due date,
+		// none if the item was already completed
+		active := updated
+		active.completed = item.completed
+		next = nextOccurrences([]todoItem{active}, []int{id}, loc)
+	}
+
+	changes.completedBy = accountID
+	updatedAt, err := updater(id, changes)
+	if err != nil {
+		return item, nil, err
+	}
+	if changes.setCompleted && changes.completed != item.completed {
+		updated.completedAt = time.Time{}
+		updated.completedBy = 0
+		if changes.completed {
+			updated.completedAt = updatedAt
+			updated.completedBy = accountID
+		}
+	}
+	item = updated
+	item.version++
+	item.updatedAt = updatedAt
+
+	if len(next) == 0 {
+		return item, nil, nil
+	}
+
+	last, err := lastPosition(item.todoListID)
+	if err != nil {
+		return item, nil, err
+	}
+	position, ok := rankBetween(last, "")
+	if !ok {
+		return item, nil, errInvalidPosition
+	}
+	next[0].position = position
+
+	created, err := inserter(item.todoListID, next)
+	return item, created, err
+}
+
+// update the todo list, the position and the parent of the items
+type todoItemsMover = func(items []todoItem) (time.Time, error)
+
+// move the items, in the order of ids, into the target todo list after
+// or before one of its items, at the end if afterID and beforeID are 0.
+// The subtasks of the items are moved with them, just after their parent.
+// A subtask moved to another list without its parent becomes a top
+// level item. The moved items are returned with their subtasks
+func moveTodoItems(
+	ids []int, targetListID, accountID, afterID, beforeID int,
+	listGetter todoListGetter,
+	getter todoItemGetter,
+	childrenSelecter todoItemChildrenSelecter,
+	neighbour todoItemNeighbourGetter,
+	lastPosition todoItemLastPositionGetter,
+	mover todoItemsMover,
+) ([]todoItem, error) {
+	if len(ids) == 0 || (afterID != 0 && beforeID != 0) || !todoItemIDsUnique(ids) {
+		return nil, errInvalidInput
+	}
+
+	target, err := listGetter(targetListID)
+	if err != nil {
+		return nil, err
+	}
+
+	if target.accountID != accountID {
+		return nil, errPermissionDenied
+	}
+
+	owners := map[int]int{target.id: target.accountID}
+	items := make([]todoItem, 0, len(ids))
+	for _, id := range ids {
+		if id == afterID || id == beforeID {
+			return nil, errInvalidInput
+		}
+
+		item, err := getter(id)
+		if err != nil {
+			return nil, err
+		}
+
+		owner, ok := owners[item.todoListID]
+		if !ok {
+			todo, err := listGetter(item.todoListID)
+			if err != nil {
+				return nil, err
+			}
+			owner = todo.accountID
+			owners[todo.id] = owner
+		}
+
+		if owner != accountID {
+			return nil, errPermissionDenied
+		}
+		items = append(items, item)
+	}
+
+	items, err = todoItemsWithSubtasks(items, childrenSelecter)
+	if err != nil {
+		return nil, err
+	}
+
+	moving := make(map[int]bool)
+	for _, item := range items {
+		moving[item.id] = true
+	}
+	if moving[afterID] || moving[beforeID] {
+		return nil, errInvalidInput
+	}
+
+	var prev, next string
+	if afterID != 0 || beforeID != 0 {
+		siblingID := afterID
+		if siblingID == 0 {
+			siblingID = beforeID
+		}
+
+		sibling, err := getter(siblingID)
+		if err != nil {
+			return nil, err
+		}
+
+		if sibling.todoListID != targetListID {
+			return nil, errInvalidInput
+		}
+
+		other, err := neighbour(targetListID, sibling.position, afterID != 0)
+		if err != nil {
+			return nil, err
+		}
+
+		if afterID != 0 {
+			prev, next = sibling.position, other
+		} else {
+			prev, next = other, sibling.position
+		}
+	} else {
+		prev, err = lastPosition(targetListID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	positions, ok := ranksBetween(prev, next, len(items))
+	if !ok {
+		return nil, errInvalidPosition
+	}
+
+	for i := range items {
+		if items[i].todoListID != targetListID && !moving[items[i].parentID] {
+			items[i].parentID = 0
+		}
+		items[i].todoListID = targetListID
+		items[i].position = positions[i]
+		items[i].version++
+	}
+
+	updatedAt, err := mover(items)
+	for i := range items {
+		items[i].updatedAt = updatedAt
+	}
+	return items, err
+}
+
+func todoItemIDsUnique(ids []int) bool {
+	m := make(map[int]struct{})
+	for _, id := range ids {
+		if _, exists := m[id]; exists {
+			return false
+		}
+		m[id] = struct{}{}
+	}
+	return true
+}
+
+func deleteTodoItem(
+	id, accountID int,
+	listGetter todoListGetter,
+	getter todoItemGetter,
+	deleter todoItemDeleter,
+) error {
+	_, err := getOwnedTodoItem(id, accountID, listGetter, getter)
+	if err != nil {
+		return err
+	}
+	return deleter(id)
+}
+
+// notes are kept out of todo_item so that lists of items stay light
+const maxTodoItemNotesLength = 65535
+
+// return empty string if the item has no notes
+type todoItemNotesGetter = func(itemID int) (string, error)
+
+// empty notes are deleted
+type todoItemNotesSaver = func(itemID int, notes string) error
+
+func validateTodoItemNotes(notes string) bool {
+	return len(notes) <= maxTodoItemNotesLength && utf8.ValidString(notes)
+}
+
+// return the item with its notes
+func getTodoItemDetail(
+	id, accountID int,
+	listGetter todoListGetter,
+	getter todoItemGetter,
+	notesGetter todoItemNotesGetter,
+) (todoItem, string, error) {
+	item, err := getOwnedTodoItem(id, accountID, listGetter, getter)
+	if err != nil {
+		return item, "", err
+	}
+
+	notes, err := notesGetter(id)
+	return item, notes, err
+}
+
+// changing the notes is a change of the item, its version is incremented
+func updateTodoItemNotes(
+	id, accountID int, notes string,
+	expectedVersion int,
+	listGetter todoListGetter,
+	getter todoItemGetter,
+	updater todoItemUpdater,
+	saver todoItemNotesSaver,
+) (todoItem, error) {
+	if !validateTodoItemNotes(notes) {
+		return todoItem{}, errInvalidInput
+	}
+
+	item, err := getOwnedTodoItem(id, accountID, listGetter, getter)
+	if err != nil {
+		return item, err
+	}
+
+	if expectedVersion != 0 && item.version != expectedVersion {
+		return item, errVersionMismatch
+	}
+
+	err = saver(id, notes)
+	if err != nil {
+		return item, err
+	}
+
+	updatedAt, err := updater(id, todoItemChanges{})
+	item.version++
+	item.updatedAt = updatedAt
+	return item, err
+}
+
+// completed items with an uncompleted subtask are kept
 func deleteTodoItemsCompleted(
 	todoListID, accountID int,
 	getter todoListGetter,
-	deleter todoItemsCompletedDeleter,
+	selecter todoItemSelecter,
+	deleter todoItemsDeleter,
 ) error {
 	todo, err := getter(todoListID)
 	if err != nil {
@@ -245,5 +1216,14 @@ func deleteTodoItemsCompleted(
 		return errPermissionDenied
 	}
 
-	return deleter(todoListID)
+	items, err := selecter(todoListID)
+	if err != nil {
+		return err
+	}
+
+	ids := completedTodoItemsToDelete(items)
+	if len(ids) == 0 {
+		return nil
+	}
+	return deleter(ids)
 }
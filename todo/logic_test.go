@@ -1,6 +1,10 @@
 package todo
 
-import "testing"
+import (
+	"strings"
+	"testing"
+	"time"
+)
 
 func TestValidateUsername(t *testing.T) {
 	ok := validateUsername("")
@@ -73,3 +77,579 @@ func TestTodoItemsContain(t *testing.T) {
 		t.Errorf("should be false")
 	}
 }
+
+func TestMoveTodoList(t *testing.T) {
+	lists := map[int]todoList{
+		1: {id: 1, accountID: 10, position: "a"},
+		2: {id: 2, accountID: 10, position: "b"},
+		3: {id: 3, accountID: 10, position: "c"},
+		4: {id: 4, accountID: 11, position: "a"},
+	}
+	getter := func(id int) (todoList, error) {
+		return lists[id], nil
+	}
+	neighbour := func(accountID int, position string, after bool) (string, error) {
+		if after && position == "a" {
+			return "b", nil
+		}
+		return "", nil
+	}
+	updated := ""
+	updater := func(id int, position string) (time.Time, error) {
+		updated = position
+		return time.Now(), nil
+	}
+
+	todo, err := moveTodoList(3, 10, 1, 0, getter, neighbour, updater)
+	if err != nil || !(todo.position > "a" && todo.position < "b") || updated != todo.position {
+		t.Errorf("should be between a and b, actual: %q %v", todo.position, err)
+	}
+
+	todo, err = moveTodoList(3, 10, 0, 1, getter, neighbour, updater)
+	if err != nil || !(todo.position < "a") {
+		t.Errorf("should be before a, actual: %q %v", todo.position, err)
+	}
+
+	_, err = moveTodoList(3, 10, 4, 0, getter, neighbour, updater)
+	if err != errPermissionDenied {
+		t.Errorf("should be permission denied, actual: %v", err)
+	}
+
+	_, err = moveTodoList(3, 10, 1, 2, getter, neighbour, updater)
+	if err != errInvalidInput {
+		t.Errorf("should be invalid input, actual: %v", err)
+	}
+}
+
+func TestSelectTodoListsPage(t *testing.T) {
+	codec := newPageTokenCodec([]byte("secret"))
+	lists := []todoList{
+		{id: 1, position: "a"},
+		{id: 2, position: "b"},
+		{id: 3, position: "c"},
+	}
+	var calledAfter []string
+	selecter := func(accountID int, after []string, limit int) ([]todoList, error) {
+		calledAfter = after
+		start := 0
+		if after != nil {
+			for i, l := range lists {
+				if l.position == after[0] {
+					start = i + 1
+				}
+			}
+		}
+		end := start + limit
+		if end > len(lists) {
+			end = len(lists)
+		}
+		return lists[start:end], nil
+	}
+
+	page, next, err := selectTodoListsPage(10, 2, "", codec, selecter)
+	if err != nil || len(page) != 2 || next == "" {
+		t.Fatalf("should return the first page, actual: %v %q %v", page, next, err)
+	}
+
+	_, _, err = selectTodoListsPage(11, 2, next, codec, selecter)
+	if err != errInvalidPageToken {
+		t.Errorf("token should not be valid for another account, actual: %v", err)
+	}
+
+	page, next, err = selectTodoListsPage(10, 2, next, codec, selecter)
+	if err != nil || len(page) != 1 || page[0].id != 3 || next != "" {
+		t.Errorf("should return the last page, actual: %v %q %v", page, next, err)
+	}
+	if len(calledAfter) != 2 || calledAfter[0] != "b" || calledAfter[1] != "2" {
+		t.Errorf("should continue after the last list, actual: %v", calledAfter)
+	}
+}
+
+func TestGetTodoListWithStats(t *testing.T) {
+	getter := func(id int) (todoList, error) {
+		return todoList{id: id, accountID: 10, name: "groceries"}, nil
+	}
+	lastActivity := time.Date(2026, 3, 10, 15, 0, 0, 0, time.UTC)
+	statsCalls := 0
+	statsGetter := func(todoListID int) (todoListStats, error) {
+		statsCalls++
+		return todoListStats{activeCount: 3, completedCount: 2, lastActivityAt: lastActivity}, nil
+	}
+
+	_, _, err := getTodoListWithStats(1, 11, getter, statsGetter)
+	if err != errPermissionDenied || statsCalls != 0 {
+		t.Errorf("should be permission denied, actual: %v", err)
+	}
+
+	todo, stats, err := getTodoListWithStats(1, 10, getter, statsGetter)
+	if err != nil || todo.id != 1 || todo.name != "groceries" {
+		t.Errorf("should return the list, actual: %+v %v", todo, err)
+	}
+	if stats.activeCount != 3 || stats.completedCount != 2 ||
+		!stats.lastActivityAt.Equal(lastActivity) {
+		t.Errorf("should return the stats, actual: %+v", stats)
+	}
+}
+
+func TestDeleteTodoList(t *testing.T) {
+	getter := func(id int) (todoList, error) {
+		return todoList{id: id, accountID: 10}, nil
+	}
+	counter := func(todoListID int) (int, error) {
+		return 3, nil
+	}
+	itemsDeleted := false
+	itemsDeleter := func(todoListID int) (int, error) {
+		itemsDeleted = true
+		return 3, nil
+	}
+	listDeleted := false
+	deleter := func(id int) error {
+		listDeleted = true
+		return nil
+	}
+
+	_, err := deleteTodoList(1, 11, false, getter, counter, itemsDeleter, deleter)
+	if err != errPermissionDenied || itemsDeleted || listDeleted {
+		t.Errorf("should be permission denied, actual: %v", err)
+	}
+
+	_, err = deleteTodoList(1, 10, true, getter, counter, itemsDeleter, deleter)
+	if err != errTodoListNotEmpty || itemsDeleted || listDeleted {
+		t.Errorf("should not delete a non empty list, actual: %v", err)
+	}
+
+	count, err := deleteTodoList(1, 10, false, getter, counter, itemsDeleter, deleter)
+	if err != nil || count != 3 || !itemsDeleted || !listDeleted {
+		t.Errorf("should delete the list and its items, actual: %d %v", count, err)
+	}
+}
+
+func TestDuplicateTodoList(t *testing.T) {
+	getter := func(id int) (todoList, error) {
+		return todoList{id: id, accountID: 10}, nil
+	}
+	selecter := func(todoListID int) ([]todoItem, error) {
+		return []todoItem{
+			{id: 1, description: "milk", position: "a"},
+			{id: 2, description: "eggs", completed: true, position: "b"},
+		}, nil
+	}
+	lastPosition := func(accountID int) (string, error) {
+		return "c", nil
+	}
+	saver := func(accountID int, name string, position string) (int, time.Time, error) {
+		return 5, time.Now(), nil
+	}
+	var inserted []todoItem
+	inserter := func(todoListID int, items []todoItem) ([]todoItem, error) {
+		inserted = items
+		return items, nil
+	}
+
+	_, _, err := duplicateTodoList(1, 10, "abc", false, false,
+		getter, selecter, lastPosition, saver, inserter)
+	if err != errInvalidInput {
+		t.Errorf("should be invalid input, actual: %v", err)
+	}
+
+	_, _, err = duplicateTodoList(1, 11, "packing list", false, false,
+		getter, selecter, lastPosition, saver, inserter)
+	if err != errPermissionDenied {
+		t.Errorf("should be permission denied, actual: %v", err)
+	}
+
+	todo, count, err := duplicateTodoList(1, 10, "packing list", false, false,
+		getter, selecter, lastPosition, saver, inserter)
+	if err != nil || todo.id != 5 || todo.position <= "c" || count != 1 {
+		t.Errorf("should copy the active items only, actual: %v %d %v", todo, count, err)
+	}
+
+	_, count, err = duplicateTodoList(1, 10, "packing list", true, true,
+		getter, selecter, lastPosition, saver, inserter)
+	if err != nil || count != 2 || inserted[1].completed {
+		t.Errorf("should copy all items as active, actual: %v %v", inserted, err)
+	}
+}
+
+func TestCreateFolder(t *testing.T) {
+	folders := map[int]folder{
+		1: {id: 1, accountID: 10},
+		2: {id: 2, accountID: 10, parentID: 1},
+		3: {id: 3, accountID: 11},
+	}
+	getter := func(id int) (folder, error) {
+		return folders[id], nil
+	}
+	lastPosition := func(accountID int) (string, error) {
+		return "a", nil
+	}
+	saver := func(accountID, parentID int, name, position string) (int, time.Time, error) {
+		return 4, time.Now(), nil
+	}
+
+	f, err := createFolder(10, 1, "Work", getter, lastPosition, saver)
+	if err != nil || f.id != 4 || f.parentID != 1 || f.position <= "a" {
+		t.Errorf("should create a sub folder, actual: %v %v", f, err)
+	}
+
+	_, err = createFolder(10, 2, "Work", getter, lastPosition, saver)
+	if err != errInvalidInput {
+		t.Errorf("should allow one level of nesting only, actual: %v", err)
+	}
+
+	_, err = createFolder(10, 3, "Work", getter, lastPosition, saver)
+	if err != errPermissionDenied {
+		t.Errorf("should be permission denied, actual: %v", err)
+	}
+
+	_, err = createFolder(10, 0, "", getter, lastPosition, saver)
+	if err != errInvalidInput {
+		t.Errorf("should be invalid input, actual: %v", err)
+	}
+}
+
+func TestUpdateTodoListVersion(t *testing.T) {
+	getter := func(id int) (todoList, error) {
+		return todoList{id: id, accountID: 10, name: "old name", version: 3}, nil
+	}
+	updateCount := 0
+	updater := func(id int, name string) (time.Time, error) {
+		updateCount++
+		return time.Now(), nil
+	}
+
+	todo, err := updateTodoList(1, 10, "new name", 2, getter, updater)
+	if err != errVersionMismatch || todo.name != "old name" || updateCount != 0 {
+		t.Errorf("should be version mismatch with the current list, actual: %v %v", todo, err)
+	}
+
+	todo, err = updateTodoList(1, 10, "new name", 3, getter, updater)
+	if err != nil || todo.version != 4 || updateCount != 1 {
+		t.Errorf("should update and increase the version, actual: %v %v", todo, err)
+	}
+
+	_, err = updateTodoList(1, 10, "new name", 0, getter, updater)
+	if err != nil || updateCount != 2 {
+		t.Errorf("should update unconditionally, actual: %v", err)
+	}
+}
+
+func TestUpdateTodoItemsCompletedVersion(t *testing.T) {
+	getter := func(id int) (todoList, error) {
+		return todoList{id: id, accountID: 10}, nil
+	}
+	selecter := func(todoListID int) ([]todoItem, error) {
+		return []todoItem{
+			{id: 1, version: 1},
+			{id: 2, version: 5},
+		}, nil
+	}
+	updated := false
+	updater := func(toBeCompleted, toBeActive []int, accountID int) error {
+		updated = true
+		return nil
+	}
+	lastPosition := func(todoListID int) (string, error) {
+		return "i", nil
+	}
+	inserter := func(todoListID int, items []todoItem) ([]todoItem, error) {
+		return items, nil
+	}
+
+	_, stale, err := updateTodoItemsCompleted(1, 10, []int{1, 2}, nil, false,
+		map[int]int{1: 1, 2: 4}, time.UTC, getter, selecter, updater, lastPosition, inserter)
+	if err != errVersionMismatch || len(stale) != 1 || stale[0].id != 2 || updated {
+		t.Errorf("should return the stale item, actual: %v %v", stale, err)
+	}
+
+	_, _, err = updateTodoItemsCompleted(1, 10, []int{1, 2}, nil, false,
+		map[int]int{1: 1, 2: 5}, time.UTC, getter, selecter, updater, lastPosition, inserter)
+	if err != nil || !updated {
+		t.Errorf("should update, actual: %v", err)
+	}
+}
+
+func TestUpdateTodoItem(t *testing.T) {
+	listGetter := func(id int) (todoList, error) {
+		return todoList{id: id, accountID: 10}, nil
+	}
+	due := time.Date(2026, 3, 10, 0, 0, 0, 0, time.UTC)
+	getter := func(id int) (todoItem, error) {
+		item := todoItem{id: id, todoListID: 1, description: "buy milk", version: 2}
+		if id == 2 {
+			item.dueAt, item.dueAllDay, item.recurrence = due, true, "FREQ=WEEKLY"
+		}
+		return item, nil
+	}
+	var updated todoItemChanges
+	updater := func(id int, changes todoItemChanges) (time.Time, error) {
+		updated = changes
+		return time.Now(), nil
+	}
+	lastPosition := func(todoListID int) (string, error) {
+		return "m", nil
+	}
+	var inserted []todoItem
+	inserter := func(todoListID int, items []todoItem) ([]todoItem, error) {
+		inserted = items
+		return items, nil
+	}
+
+	changes := todoItemChanges{setDescription: true, description: "buy eggs"}
+	item, _, err := updateTodoItem(1, 10, changes, 0, time.UTC,
+		listGetter, getter, updater, lastPosition, inserter)
+	if err != nil || item.description != "buy eggs" || item.version != 3 ||
+		updated.setCompleted {
+		t.Errorf("should update the description only, actual: %v %v", item, err)
+	}
+
+	_, _, err = updateTodoItem(1, 11, changes, 0, time.UTC,
+		listGetter, getter, updater, lastPosition, inserter)
+	if err != errPermissionDenied {
+		t.Errorf("should be permission denied, actual: %v", err)
+	}
+
+	item, _, err = updateTodoItem(1, 10, changes, 1, time.UTC,
+		listGetter, getter, updater, lastPosition, inserter)
+	if err != errVersionMismatch || item.description != "buy milk" {
+		t.Errorf("should be version mismatch, actual: %v %v", item, err)
+	}
+
+	changes = todoItemChanges{setCompleted: true, completed: true}
+	item, created, err := updateTodoItem(1, 10, changes, 0, time.UTC,
+		listGetter, getter, updater, lastPosition, inserter)
+	if err != nil || !item.completed || item.completedBy != 10 ||
+		item.completedAt.IsZero() || updated.completedBy != 10 {
+		t.Errorf("should record the completion, actual: %+v %v", item, err)
+	}
+	if len(created) != 0 || inserted != nil {
+		t.Errorf("should not create an occurrence, actual: %+v", created)
+	}
+
+	item, created, err = updateTodoItem(2, 10, changes, 0, time.UTC,
+		listGetter, getter, updater, lastPosition, inserter)
+	if err != nil || !item.completed || len(created) != 1 ||
+		!created[0].dueAt.Equal(due.AddDate(0, 0, 7)) || created[0].position <= "m" {
+		t.Errorf("should create the next occurrence, actual: %+v %v", created, err)
+	}
+
+	changes = todoItemChanges{setDescription: true, description: "egg"}
+	_, _, err = updateTodoItem(1, 10, changes, 0, time.UTC,
+		listGetter, getter, updater, lastPosition, inserter)
+	if err != errInvalidInput {
+		t.Errorf("should be invalid input, actual: %v", err)
+	}
+}
+
+func TestMoveTodoItems(t *testing.T) {
+	lists := map[int]todoList{
+		1: {id: 1, accountID: 10},
+		2: {id: 2, accountID: 10},
+		3: {id: 3, accountID: 11},
+	}
+	items := map[int]todoItem{
+		1: {id: 1, todoListID: 1, position: "a"},
+		2: {id: 2, todoListID: 1, position: "b"},
+		3: {id: 3, todoListID: 2, position: "c"},
+		4: {id: 4, todoListID: 3, position: "d"},
+	}
+	listGetter := func(id int) (todoList, error) {
+		return lists[id], nil
+	}
+	getter := func(id int) (todoItem, error) {
+		return items[id], nil
+	}
+	neighbour := func(todoListID int, position string, after bool) (string, error) {
+		return "", nil
+	}
+	lastPosition := func(todoListID int) (string, error) {
+		return "c", nil
+	}
+	childrenSelecter := func(parentIDs []int) ([]todoItem, error) {
+		return nil, nil
+	}
+	var moved []string
+	mover := func(items []todoItem) (time.Time, error) {
+		moved = make([]string, 0, len(items))
+		for _, item := range items {
+			moved = append(moved, item.position)
+		}
+		return time.Now(), nil
+	}
+
+	result, err := moveTodoItems([]int{2, 1}, 2, 10, 0, 0,
+		listGetter, getter, childrenSelecter, neighbour, lastPosition, mover)
+	if err != nil || len(result) != 2 || result[0].todoListID != 2 {
+		t.Fatalf("should move the items, actual: %v %v", result, err)
+	}
+	if !(moved[0] > "c" && moved[1] > moved[0]) || result[0].id != 2 {
+		t.Errorf("should keep the order of the ids at the end, actual: %v", moved)
+	}
+
+	result, err = moveTodoItems([]int{1}, 2, 10, 0, 3,
+		listGetter, getter, childrenSelecter, neighbour, lastPosition, mover)
+	if err != nil || !(result[0].position < "c") {
+		t.Errorf("should be before item 3, actual: %v %v", result, err)
+	}
+
+	_, err = moveTodoItems([]int{4}, 2, 10, 0, 0,
+		listGetter, getter, childrenSelecter, neighbour, lastPosition, mover)
+	if err != errPermissionDenied {
+		t.Errorf("should not move items of other accounts, actual: %v", err)
+	}
+
+	_, err = moveTodoItems([]int{1}, 3, 10, 0, 0,
+		listGetter, getter, childrenSelecter, neighbour, lastPosition, mover)
+	if err != errPermissionDenied {
+		t.Errorf("should not move into lists of other accounts, actual: %v", err)
+	}
+
+	_, err = moveTodoItems([]int{1, 1}, 2, 10, 0, 0,
+		listGetter, getter, childrenSelecter, neighbour, lastPosition, mover)
+	if err != errInvalidInput {
+		t.Errorf("should be invalid input, actual: %v", err)
+	}
+}
+
+func TestParseDueAt(t *testing.T) {
+	due, allDay, err := parseDueAt("2020-07-15")
+	if err != nil || !allDay || formatDueAt(due, allDay) != "2020-07-15" {
+		t.Errorf("should be an all-day due date, actual: %v %v %v", due, allDay, err)
+	}
+
+	due, allDay, err = parseDueAt("2020-07-15T10:30:00+07:00")
+	if err != nil || allDay || formatDueAt(due, allDay) != "2020-07-15T03:30:00Z" {
+		t.Errorf("should be a due time in UTC, actual: %v %v %v", due, allDay, err)
+	}
+
+	due, _, err = parseDueAt("")
+	if err != nil || !due.IsZero() {
+		t.Errorf("should be no due date, actual: %v %v", due, err)
+	}
+
+	_, _, err = parseDueAt("15/07/2020")
+	if err != errInvalidInput {
+		t.Errorf("should be invalid input, actual: %v", err)
+	}
+}
+
+func TestValidateTodoItemSchedule(t *testing.T) {
+	day := time.Date(2020, 7, 15, 0, 0, 0, 0, time.UTC)
+
+	item := todoItem{dueAt: day, dueAllDay: true, remindAt: day.Add(9 * time.Hour)}
+	if !validateTodoItemSchedule(item) {
+		t.Error("a reminder on the day of the due date should be valid")
+	}
+
+	item = todoItem{dueAt: day.Add(9 * time.Hour), remindAt: day.Add(10 * time.Hour)}
+	if validateTodoItemSchedule(item) {
+		t.Error("a reminder after the due time should be invalid")
+	}
+
+	item = todoItem{remindAt: day}
+	if !validateTodoItemSchedule(item) {
+		t.Error("a reminder without due date should be valid")
+	}
+}
+
+func TestTodoItemPageKeys(t *testing.T) {
+	item := todoItem{id: 3, position: "i"}
+
+	keys := todoItemPageKeys(item, sortByDueAt)
+	if len(keys) != 3 || keys[0] != "9999-12-31 23:59:59" {
+		t.Errorf("items without due date should sort last, actual: %v", keys)
+	}
+
+	item.dueAt = time.Date(2020, 7, 15, 9, 0, 0, 0, time.UTC)
+	keys = todoItemPageKeys(item, sortByDueAt)
+	if keys[0] != "2020-07-15 09:00:00" || keys[1] != "i" || keys[2] != "3" {
+		t.Errorf("should be due, position and id, actual: %v", keys)
+	}
+}
+
+func TestTodoItemPageKeysSort(t *testing.T) {
+	item := todoItem{
+		id:        3,
+		position:  "i",
+		priority:  priorityHigh,
+		completed: true,
+		createdAt: time.Date(2020, 7, 15, 9, 0, 0, 0, time.UTC),
+	}
+
+	cases := []struct {
+		sortBy   todoItemSort
+		expected []string
+	}{
+		{sortByPosition, []string{"i", "3"}},
+		{sortByCreatedAt, []string{"2020-07-15 09:00:00", "3"}},
+		{sortByPriority, []string{"1", "i", "3"}},
+		{sortByCompletedLast, []string{"1", "i", "3"}},
+	}
+	for _, c := range cases {
+		keys := todoItemPageKeys(item, c.sortBy)
+		if strings.Join(keys, ",") != strings.Join(c.expected, ",") {
+			t.Errorf("sort %d: expected %v, actual %v", c.sortBy, c.expected, keys)
+		}
+	}
+
+	if validateTodoItemSort(sortByCompletedLast + 1) {
+		t.Error("unknown sort should be invalid")
+	}
+}
+
+func TestUpdateTodoItemNotes(t *testing.T) {
+	listGetter := func(id int) (todoList, error) {
+		return todoList{id: id, accountID: 10}, nil
+	}
+	getter := func(id int) (todoItem, error) {
+		return todoItem{id: id, todoListID: 1, version: 2}, nil
+	}
+	updateCount := 0
+	updater := func(id int, changes todoItemChanges) (time.Time, error) {
+		updateCount++
+		return time.Now(), nil
+	}
+	saved := ""
+	saver := func(itemID int, notes string) error {
+		saved = notes
+		return nil
+	}
+
+	_, err := updateTodoItemNotes(1, 10, string(make([]byte, 65536)), 0,
+		listGetter, getter, updater, saver)
+	if err != errInvalidInput {
+		t.Errorf("should be invalid input, actual: %v", err)
+	}
+
+	_, err = updateTodoItemNotes(1, 10, "# notes", 1,
+		listGetter, getter, updater, saver)
+	if err != errVersionMismatch || saved != "" {
+		t.Errorf("should be version mismatch, actual: %v", err)
+	}
+
+	item, err := updateTodoItemNotes(1, 10, "# notes", 2,
+		listGetter, getter, updater, saver)
+	if err != nil || saved != "# notes" || item.version != 3 || updateCount != 1 {
+		t.Errorf("should save the notes, actual: %v %v", item.version, err)
+	}
+}
+
+func TestValidateTodoItemQuery(t *testing.T) {
+	if !validateTodoItemQuery(todoItemQuery{status: statusCompleted, text: "milk"}) {
+		t.Error("completed items with a text should be valid")
+	}
+	if validateTodoItemQuery(todoItemQuery{status: statusCompleted + 1}) {
+		t.Error("unknown status should be invalid")
+	}
+	if validateTodoItemQuery(todoItemQuery{text: strings.Repeat("a", 101)}) {
+		t.Error("too long text should be invalid")
+	}
+}
+
+func TestEscapeLike(t *testing.T) {
+	actual := escapeLike(`50%_off\`)
+	if actual != `50\%\_off\\` {
+		t.Errorf("should escape the wildcards, actual: %s", actual)
+	}
+}
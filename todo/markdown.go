@@ -0,0 +1,187 @@
+package todo
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// renderMarkdown converts the notes of an item to HTML safe to be
+// inserted into a page. Only a subset of Markdown is supported:
+// headings (#), paragraphs, blockquotes (>), lists (-, *, + and 1.),
+// fenced code blocks (```), `code`, **strong**, *emphasis* and
+// [links](url) with a http, https or mailto url.
+// Raw HTML is never passed through, it is escaped like any other text.
+func renderMarkdown(src string) string {
+	r := markdownRenderer{}
+	for _, line := range strings.Split(strings.ReplaceAll(src, "\r\n", "\n"), "\n") {
+		r.line(line)
+	}
+	r.closeBlocks()
+	if r.inCode {
+		r.out.WriteString("</code></pre>\n")
+	}
+	return r.out.String()
+}
+
+var (
+	headingRegexp     = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*$`)
+	bulletItemRegexp  = regexp.MustCompile(`^[-*+]\s+(.*)$`)
+	orderedItemRegexp = regexp.MustCompile(`^\d{1,9}[.)]\s+(.*)$`)
+	codeSpanRegexp    = regexp.MustCompile("`([^`]+)`")
+	linkRegexp        = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+	strongRegexp      = regexp.MustCompile(`\*\*([^*]+)\*\*`)
+	emphasisRegexp    = regexp.MustCompile(`\*([^*]+)\*`)
+)
+
+type markdownRenderer struct {
+	out strings.Builder
+	// "p" or "blockquote" with its lines not written yet
+	block      string
+	blockLines []string
+	// "ul" or "ol" when inside a list
+	list   string
+	inCode bool
+}
+
+func (r *markdownRenderer) line(line string) {
+	trimmed := strings.TrimSpace(line)
+
+	if r.inCode {
+		if strings.HasPrefix(trimmed, "```") {
+			r.out.WriteString("</code></pre>\n")
+			r.inCode = false
+			return
+		}
+		r.out.WriteString(html.EscapeString(line) + "\n")
+		return
+	}
+
+	if strings.HasPrefix(trimmed, "```") {
+		r.closeBlocks()
+		r.out.WriteString("<pre><code>")
+		r.inCode = true
+		return
+	}
+
+	if trimmed == "" {
+		r.closeBlocks()
+		return
+	}
+
+	if m := headingRegexp.FindStringSubmatch(trimmed); m != nil {
+		r.closeBlocks()
+		tag := "h" + strconv.Itoa(len(m[1]))
+		r.out.WriteString("<" + tag + ">" + renderInline(m[2]) + "</" + tag + ">\n")
+		return
+	}
+
+	if m := bulletItemRegexp.FindStringSubmatch(trimmed); m != nil {
+		r.listItem("ul", m[1])
+		return
+	}
+	if m := orderedItemRegexp.FindStringSubmatch(trimmed); m != nil {
+		r.listItem("ol", m[1])
+		return
+	}
+
+	block, text := "p", trimmed
+	if strings.HasPrefix(trimmed, ">") {
+		block, text = "blockquote", strings.TrimSpace(trimmed[1:])
+	}
+	if r.block != block || r.list != "" {
+		r.closeBlocks()
+	}
+	r.block = block
+	r.blockLines = append(r.blockLines, text)
+}
+
+func (r *markdownRenderer) listItem(list, text string) {
+	if r.list != list || r.block != "" {
+		r.closeBlocks()
+		r.out.WriteString("<" + list + ">\n")
+		r.list = list
+	}
+	r.out.WriteString("<li>" + renderInline(text) + "</li>\n")
+}
+
+func (r *markdownRenderer) closeBlocks() {
+	if r.block != "" {
+		lines := make([]string, 0, len(r.blockLines))
+		for _, line := range r.blockLines {
+			lines = append(lines, renderInline(line))
+		}
+		text := strings.Join(lines, "\n")
+
+		if r.block == "blockquote" {
+			r.out.WriteString("<blockquote><p>" + text + "</p></blockquote>\n")
+		} else {
+			r.out.WriteString("<p>" + text + "</p>\n")
+		}
+		r.block = ""
+		r.blockLines = nil
+	}
+
+	if r.list != "" {
+		r.out.WriteString("</" + r.list + ">\n")
+		r.list = ""
+	}
+}
+
+// code spans are rendered verbatim, links and emphasis elsewhere
+func renderInline(text string) string {
+	var b strings.Builder
+	last := 0
+	for _, m := range codeSpanRegexp.FindAllStringSubmatchIndex(text, -1) {
+		b.WriteString(renderLinks(text[last:m[0]]))
+		b.WriteString("<code>" + html.EscapeString(text[m[2]:m[3]]) + "</code>")
+		last = m[1]
+	}
+	b.WriteString(renderLinks(text[last:]))
+	return b.String()
+}
+
+// links with an unsafe url are rendered as their text only
+func renderLinks(text string) string {
+	var b strings.Builder
+	last := 0
+	for _, m := range linkRegexp.FindAllStringSubmatchIndex(text, -1) {
+		b.WriteString(renderEmphasis(text[last:m[0]]))
+
+		label := renderEmphasis(text[m[2]:m[3]])
+		href := text[m[4]:m[5]]
+		if safeLinkURL(href) {
+			b.WriteString(`<a href="` + html.EscapeString(href) +
+				`" rel="nofollow noopener noreferrer">` + label + "</a>")
+		} else {
+			b.WriteString(label)
+		}
+		last = m[1]
+	}
+	b.WriteString(renderEmphasis(text[last:]))
+	return b.String()
+}
+
+// escape the text, then emphasis markers which are not HTML special chars
+func renderEmphasis(text string) string {
+	escaped := html.EscapeString(text)
+	escaped = strongRegexp.ReplaceAllString(escaped, "<strong>$1</strong>")
+	return emphasisRegexp.ReplaceAllString(escaped, "<em>$1</em>")
+}
+
+func safeLinkURL(href string) bool {
+	u, err := url.Parse(href)
+	if err != nil {
+		return false
+	}
+
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+		return u.Host != ""
+	case "mailto":
+		return u.Opaque != ""
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+package todo
+
+import "testing"
+
+func TestRenderMarkdown(t *testing.T) {
+	cases := []struct {
+		name, src, expected string
+	}{
+		{"paragraphs", "first line\nsecond\n\nother",
+			"<p>first line\nsecond</p>\n<p>other</p>\n"},
+		{"heading", "## Shopping ##", "<h2>Shopping</h2>\n"},
+		{"emphasis", "**milk** and *eggs*",
+			"<p><strong>milk</strong> and <em>eggs</em></p>\n"},
+		{"lists", "- a\n* b\n1. c",
+			"<ul>\n<li>a</li>\n<li>b</li>\n</ul>\n<ol>\n<li>c</li>\n</ol>\n"},
+		{"blockquote", "> quoted\n> text",
+			"<blockquote><p>quoted\ntext</p></blockquote>\n"},
+		{"code", "run `a <b> **c**`",
+			"<p>run <code>a &lt;b&gt; **c**</code></p>\n"},
+		{"code block", "```\n<div>\n```",
+			"<pre><code>&lt;div&gt;\n</code></pre>\n"},
+		{"unclosed code block", "```\nx", "<pre><code>x\n</code></pre>\n"},
+		{"link", "[docs](https://example.com/a?b=1&c=2)",
+			`<p><a href="https://example.com/a?b=1&amp;c=2" rel="nofollow noopener noreferrer">docs</a></p>` + "\n"},
+		{"emphasis in url", "[x](https://example.com/*a*)",
+			`<p><a href="https://example.com/*a*" rel="nofollow noopener noreferrer">x</a></p>` + "\n"},
+		{"raw html", `<script>alert("x")</script>`,
+			"<p>&lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt;</p>\n"},
+		{"javascript link", "[click](javascript:alert(1))",
+			"<p>click)</p>\n"},
+		{"attribute injection", `[x](https://a.com/"onmouseover="alert(1))`,
+			`<p><a href="https://a.com/&#34;onmouseover=&#34;alert(1" rel="nofollow noopener noreferrer">x</a>)</p>` + "\n"},
+		{"empty", "", ""},
+	}
+
+	for _, c := range cases {
+		result := renderMarkdown(c.src)
+		if result != c.expected {
+			t.Errorf("%s: expected %q, actual %q", c.name, c.expected, result)
+		}
+	}
+}
+
+func TestSafeLinkURL(t *testing.T) {
+	cases := map[string]bool{
+		"https://example.com":     true,
+		"HTTP://example.com":      true,
+		"mailto:a@example.com":    true,
+		"javascript:alert(1)":     false,
+		"JaVaScRiPt:alert(1)":     false,
+		"data:text/html;base64,x": false,
+		"//example.com":           false,
+		"/relative":               false,
+	}
+
+	for href, expected := range cases {
+		if safeLinkURL(href) != expected {
+			t.Errorf("%s: expected %v", href, expected)
+		}
+	}
+}
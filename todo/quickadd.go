@@ -0,0 +1,237 @@
+package todo
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"todo-app/quickadd"
+)
+
+// Quick add creates an item from a line typed by the user, parsed by the
+// quickadd package. Dates are relative to the timezone of the account,
+// an IANA name like "Europe/Paris"
+type accountTimezoneGetter = func(accountID int) (string, error)
+type accountTimezoneUpdater = func(accountID int, timezone string) error
+
+// sql.ErrNoRows if the account has no list with the name, ignoring case
+type todoListByNameGetter = func(accountID int, name string) (todoList, error)
+
+// the first list by position, sql.ErrNoRows if the account has none
+type firstTodoListGetter = func(accountID int) (todoList, error)
+
+// the list of quick add items when the account has no list
+const defaultTodoListName = "Inbox"
+
+func loadTimezone(name string) (*time.Location, error) {
+	if name == "" || name == "Local" {
+		return nil, errInvalidInput
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, errInvalidInput
+	}
+	return loc, nil
+}
+
+// the timezone of the account, UTC if it can't be loaded
+func accountLocation(accountID int, getter accountTimezoneGetter) (*time.Location, error) {
+	timezone, err := getter(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	loc, err := loadTimezone(timezone)
+	if err != nil {
+		return time.UTC, nil
+	}
+	return loc, nil
+}
+
+// now in the timezone of the account
+func accountNow(accountID int, now time.Time, getter accountTimezoneGetter) (time.Time, error) {
+	loc, err := accountLocation(accountID, getter)
+	if err != nil {
+		return now, err
+	}
+	return now.In(loc), nil
+}
+
+func setAccountTimezone(accountID int, timezone string, updater accountTimezoneUpdater) error {
+	_, err := loadTimezone(timezone)
+	if err != nil {
+		return err
+	}
+	return updater(accountID, timezone)
+}
+
+type quickAddResult struct {
+	item todoItem
+	// the names of the labels of the line
+	labelNames []string
+	// the labels which don't exist yet, created unless it is a dry run
+	newLabelNames []string
+}
+
+// the item of the parsed line, an all-day due date is the day in UTC
+func quickAddItem(parsed quickadd.Result) todoItem {
+	item := todoItem{
+		description: parsed.Description,
+		recurrence:  parsed.Recurrence,
+		priority:    parsed.Priority,
+	}
+	if parsed.AllDay {
+		y, m, d := parsed.Due.Date()
+		item.dueAt = time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+		item.dueAllDay = true
+	} else if !parsed.Due.IsZero() {
+		item.dueAt = parsed.Due.UTC()
+	}
+	return item
+}
+
+// the list of todoListID if it is set, then the one named in the line,
+// the first list of the account, or a new default list
+func quickAddTodoList(
+	accountID, todoListID int, name string, dryRun bool,
+	byName todoListByNameGetter,
+	first firstTodoListGetter,
+	lastListPosition todoListLastPositionGetter,
+	listSaver todoListSaver,
+) (int, error) {
+	if todoListID != 0 {
+		return todoListID, nil
+	}
+
+	if name != "" {
+		todo, err := byName(accountID, name)
+		return todo.id, err
+	}
+
+	todo, err := first(accountID)
+	if err != sql.ErrNoRows {
+		return todo.id, err
+	}
+	if dryRun {
+		return 0, nil
+	}
+
+	todo, err = createTodoList(accountID, defaultTodoListName, lastListPosition, listSaver)
+	return todo.id, err
+}
+
+// the ids of the labels with the names, ignoring case, creating the
+// missing ones unless it is a dry run
+func quickAddLabels(
+	accountID int, names []string, dryRun bool,
+	selecter labelsByAccountSelecter,
+	saver labelSaver,
+) ([]int, []string, error) {
+	labels, err := selecter(accountID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ids := make([]int, 0, len(names))
+	newNames := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if seen[strings.ToLower(name)] {
+			continue
+		}
+		seen[strings.ToLower(name)] = true
+
+		found := false
+		for _, l := range labels {
+			if strings.EqualFold(l.name, name) {
+				ids = append(ids, l.id)
+				found = true
+				break
+			}
+		}
+		if found {
+			continue
+		}
+
+		if !validateLabelName(name) {
+			return nil, nil, errInvalidInput
+		}
+		newNames = append(newNames, name)
+		if dryRun {
+			continue
+		}
+
+		l, err := createLabel(accountID, name, "", selecter, saver)
+		if err != nil {
+			return nil, nil, err
+		}
+		ids = append(ids, l.id)
+	}
+	return ids, newNames, nil
+}
+
+// parse the line and create its item, in a dry run nothing is saved and
+// the item has no id
+func quickAddTodoItem(
+	accountID int, text string, todoListID int, dryRun bool,
+	now time.Time,
+	timezoneGetter accountTimezoneGetter,
+	byName todoListByNameGetter,
+	first firstTodoListGetter,
+	lastListPosition todoListLastPositionGetter,
+	listSaver todoListSaver,
+	listGetter todoListGetter,
+	labelsSelecter labelsByAccountSelecter,
+	labelSaver labelSaver,
+	itemGetter todoItemGetter,
+	lastPosition todoItemLastPositionGetter,
+	inserter todoItemsInserter,
+) (quickAddResult, error) {
+	now, err := accountNow(accountID, now, timezoneGetter)
+	if err != nil {
+		return quickAddResult{}, err
+	}
+
+	parsed := quickadd.Parse(text, now)
+	item := quickAddItem(parsed)
+	result := quickAddResult{labelNames: parsed.Labels}
+	if !validateTodoItemDescription(item.description) || !validateTodoItemSchedule(item) {
+		return result, errInvalidInput
+	}
+
+	item.todoListID, err = quickAddTodoList(
+		accountID, todoListID, parsed.List, dryRun,
+		byName, first, lastListPosition, listSaver,
+	)
+	if err != nil {
+		return result, err
+	}
+
+	if item.todoListID != 0 {
+		todo, err := listGetter(item.todoListID)
+		if err != nil {
+			return result, err
+		}
+		if todo.accountID != accountID {
+			return result, errPermissionDenied
+		}
+	}
+
+	item.labelIDs, result.newLabelNames, err = quickAddLabels(
+		accountID, parsed.Labels, dryRun, labelsSelecter, labelSaver)
+	if err != nil {
+		return result, err
+	}
+
+	if dryRun {
+		result.item = item
+		return result, nil
+	}
+
+	result.item, err = createTodoItem(
+		accountID, item,
+		listGetter, itemGetter, lastPosition, inserter,
+	)
+	return result, err
+}
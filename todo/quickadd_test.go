@@ -0,0 +1,122 @@
+package todo
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSetAccountTimezone(t *testing.T) {
+	var updated string
+	updater := func(accountID int, timezone string) error {
+		updated = timezone
+		return nil
+	}
+
+	for _, timezone := range []string{"", "Local", "Mars/Olympus"} {
+		err := setAccountTimezone(10, timezone, updater)
+		if err != errInvalidInput || updated != "" {
+			t.Errorf("%q should be invalid, actual: %v", timezone, err)
+		}
+	}
+
+	err := setAccountTimezone(10, "Europe/Paris", updater)
+	if err != nil || updated != "Europe/Paris" {
+		t.Errorf("should update the timezone, actual: %v %q", err, updated)
+	}
+}
+
+func TestQuickAddTodoItem(t *testing.T) {
+	timezoneGetter := func(accountID int) (string, error) {
+		return "America/New_York", nil
+	}
+	byName := func(accountID int, name string) (todoList, error) {
+		if name == "work" {
+			return todoList{id: 2, accountID: accountID}, nil
+		}
+		return todoList{}, sql.ErrNoRows
+	}
+	first := func(accountID int) (todoList, error) {
+		return todoList{}, sql.ErrNoRows
+	}
+	lastListPosition := func(accountID int) (string, error) {
+		return "", nil
+	}
+	var savedList string
+	listSaver := func(accountID int, name, position string) (int, time.Time, error) {
+		savedList = name
+		return 3, time.Now(), nil
+	}
+	listGetter := func(id int) (todoList, error) {
+		return todoList{id: id, accountID: 10}, nil
+	}
+	labels := []label{{id: 7, accountID: 10, name: "Finance"}}
+	labelsSelecter := func(accountID int) ([]label, error) {
+		return labels, nil
+	}
+	labelSaver := func(accountID int, name, color string) (int, time.Time, error) {
+		labels = append(labels, label{id: 8, accountID: accountID, name: name})
+		return 8, time.Now(), nil
+	}
+	itemGetter := func(id int) (todoItem, error) {
+		return todoItem{}, sql.ErrNoRows
+	}
+	lastPosition := func(todoListID int) (string, error) {
+		return "", nil
+	}
+	var inserted []todoItem
+	inserter := func(todoListID int, items []todoItem) ([]todoItem, error) {
+		inserted = items
+		items[0].id = 1
+		return items, nil
+	}
+	// 11pm in New York, the next day in UTC
+	now := time.Date(2026, 3, 11, 3, 0, 0, 0, time.UTC)
+	quickAdd := func(text string, dryRun bool) (quickAddResult, error) {
+		return quickAddTodoItem(10, text, 0, dryRun, now,
+			timezoneGetter, byName, first, lastListPosition, listSaver,
+			listGetter, labelsSelecter, labelSaver,
+			itemGetter, lastPosition, inserter)
+	}
+
+	result, err := quickAdd("Pay rent tomorrow 9am #finance #home !high every month", true)
+	if err != nil {
+		t.Fatalf("should parse the text, actual: %v", err)
+	}
+	expected := todoItem{
+		description: "Pay rent",
+		dueAt:       time.Date(2026, 3, 11, 13, 0, 0, 0, time.UTC),
+		priority:    priorityHigh,
+		recurrence:  "FREQ=MONTHLY",
+		labelIDs:    []int{7},
+	}
+	if !reflect.DeepEqual(result.item, expected) {
+		t.Errorf("expected %+v, actual: %+v", expected, result.item)
+	}
+	if !reflect.DeepEqual(result.newLabelNames, []string{"home"}) ||
+		savedList != "" || len(labels) != 1 || inserted != nil {
+		t.Errorf("a dry run should save nothing, actual: %+v", result)
+	}
+
+	result, err = quickAdd("Buy milk tomorrow #home", false)
+	if err != nil || result.item.id != 1 || result.item.todoListID != 3 ||
+		savedList != defaultTodoListName {
+		t.Fatalf("should create the item in the default list, actual: %+v %v", result, err)
+	}
+	if !result.item.dueAllDay ||
+		!result.item.dueAt.Equal(time.Date(2026, 3, 11, 0, 0, 0, 0, time.UTC)) ||
+		!reflect.DeepEqual(result.item.labelIDs, []int{8}) {
+		t.Errorf("should create the label and the all-day due date, actual: %+v", result.item)
+	}
+
+	result, err = quickAdd("Send report @work", false)
+	if err != nil || result.item.todoListID != 2 {
+		t.Errorf("should create the item in the named list, actual: %+v %v", result, err)
+	}
+
+	_, err = quickAdd("Call @nowhere", false)
+	if err != sql.ErrNoRows {
+		t.Errorf("an unknown list should not be found, actual: %v", err)
+	}
+}
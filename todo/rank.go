@@ -0,0 +1,159 @@
+package todo
+
+import "strings"
+
+// Ranks are strings over rankDigits compared byte by byte, so a new rank
+// can always be made between two existing ones without renumbering the
+// siblings. A rank never ends with the zero digit, which keeps a gap
+// available below every rank.
+const rankDigits = "0123456789abcdefghijklmnopqrstuvwxyz"
+
+const rankBase = len(rankDigits)
+
+func rankDigit(rank string, i int) int {
+	if i >= len(rank) {
+		return 0
+	}
+	return strings.IndexByte(rankDigits, rank[i])
+}
+
+func validateRank(rank string) bool {
+	if len(rank) == 0 || rank[len(rank)-1] == rankDigits[0] {
+		return false
+	}
+	for i := range rank {
+		if rankDigit(rank, i) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// rankBetween returns a rank strictly between prev and next.
+// An empty prev means the beginning, an empty next means the end.
+// ok is false if the bounds are not valid ranks in increasing order
+func rankBetween(prev, next string) (string, bool) {
+	if prev != "" && !validateRank(prev) {
+		return "", false
+	}
+	if next != "" && !validateRank(next) {
+		return "", false
+	}
+	if next != "" && prev >= next {
+		return "", false
+	}
+	switch {
+	case prev != "" && next == "":
+		return rankAfter(prev), true
+	case prev == "" && next != "":
+		return rankBefore(next), true
+	}
+	return rankMidpoint(prev, next), true
+}
+
+// rankAfter increments rank as a number of its length, so appending
+// again and again keeps the ranks short. When all its digits are the
+// last one, it is extended with a counter one digit longer than itself
+func rankAfter(rank string) string {
+	if strings.Count(rank, rankDigits[rankBase-1:]) == len(rank) {
+		return rank + strings.Repeat(rankDigits[:1], len(rank)) + rankDigits[1:2]
+	}
+
+	next := rankAdd(rank, 1)
+	if next[len(next)-1] == rankDigits[0] {
+		next = rankAdd(next, 1)
+	}
+	return next
+}
+
+// rankBefore decrements rank as a number of its length, the same way.
+// Below "0...01" it is extended with the last digit
+func rankBefore(rank string) string {
+	prev := rankAdd(rank, -1)
+	if strings.Count(prev, rankDigits[:1]) == len(prev) {
+		return prev + strings.Repeat(rankDigits[rankBase-1:], len(rank)+1)
+	}
+
+	if prev[len(prev)-1] == rankDigits[0] {
+		prev = rankAdd(prev, -1)
+	}
+	return prev
+}
+
+// rankAdd adds delta, 1 or -1, to rank as a number of its length.
+// The caller checks the result doesn't overflow
+func rankAdd(rank string, delta int) string {
+	digits := []byte(rank)
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := rankDigit(rank, i) + delta
+		carry := 0
+		if d < 0 {
+			d, carry = rankBase-1, -1
+		} else if d >= rankBase {
+			d, carry = 0, 1
+		}
+		digits[i] = rankDigits[d]
+		if carry == 0 {
+			break
+		}
+	}
+	return string(digits)
+}
+
+func rankMidpoint(prev, next string) string {
+	if next != "" {
+		n := 0
+		for n < len(next) && rankDigit(prev, n) == rankDigit(next, n) {
+			n++
+		}
+		if n > 0 {
+			rest := ""
+			if n < len(prev) {
+				rest = prev[n:]
+			}
+			return next[:n] + rankMidpoint(rest, next[n:])
+		}
+	}
+
+	low := rankDigit(prev, 0)
+	high := rankBase
+	if next != "" {
+		high = rankDigit(next, 0)
+	}
+
+	if high-low > 1 {
+		return string(rankDigits[(low+high)/2])
+	}
+
+	if len(next) > 1 {
+		return next[:1]
+	}
+
+	rest := ""
+	if len(prev) > 1 {
+		rest = prev[1:]
+	}
+	return string(rankDigits[low]) + rankMidpoint(rest, "")
+}
+
+// ranksBetween returns n increasing ranks strictly between prev and next,
+// splitting the interval in halves so their length grows with log(n) only
+func ranksBetween(prev, next string, n int) ([]string, bool) {
+	result := make([]string, 0, n)
+	if n == 0 {
+		return result, true
+	}
+
+	mid, ok := rankBetween(prev, next)
+	if !ok {
+		return nil, false
+	}
+
+	before, _ := ranksBetween(prev, mid, (n-1)/2)
+	after, _ := ranksBetween(mid, next, n-1-(n-1)/2)
+
+	result = append(result, before...)
+	result = append(result, mid)
+	result = append(result, after...)
+	return result, true
+}
@@ -0,0 +1,109 @@
+package todo
+
+import "testing"
+
+func TestRankBetween(t *testing.T) {
+	cases := []struct {
+		prev, next string
+	}{
+		{"", ""},
+		{"", "1"},
+		{"z", ""},
+		{"a", "b"},
+		{"a", "a5"},
+		{"a1", "b"},
+		{"a", "a01"},
+		{"0001", "0002"},
+	}
+
+	for _, c := range cases {
+		rank, ok := rankBetween(c.prev, c.next)
+		if !ok {
+			t.Errorf("between %q and %q should be ok", c.prev, c.next)
+			continue
+		}
+		if !validateRank(rank) {
+			t.Errorf("between %q and %q: invalid rank %q", c.prev, c.next, rank)
+		}
+		if rank <= c.prev || (c.next != "" && rank >= c.next) {
+			t.Errorf("between %q and %q: out of range %q", c.prev, c.next, rank)
+		}
+	}
+}
+
+func TestRankBetweenInvalid(t *testing.T) {
+	cases := []struct {
+		prev, next string
+	}{
+		{"b", "a"},
+		{"a", "a"},
+		{"a0", "b"},
+		{"A", ""},
+	}
+
+	for _, c := range cases {
+		_, ok := rankBetween(c.prev, c.next)
+		if ok {
+			t.Errorf("between %q and %q should not be ok", c.prev, c.next)
+		}
+	}
+}
+
+func TestRankBetweenRepeatedInsert(t *testing.T) {
+	prev, next := "", ""
+	for i := 0; i < 200; i++ {
+		rank, ok := rankBetween(prev, next)
+		if !ok || rank <= prev || (next != "" && rank >= next) {
+			t.Fatalf("step %d: bad rank %q between %q and %q", i, rank, prev, next)
+		}
+		if i%2 == 0 {
+			prev = rank
+		} else {
+			next = rank
+		}
+	}
+}
+
+func TestRankBetweenAppend(t *testing.T) {
+	last, first := "", ""
+	for i := 0; i < 5000; i++ {
+		rank, ok := rankBetween(last, "")
+		if !ok || rank <= last || !validateRank(rank) {
+			t.Fatalf("step %d: bad rank %q after %q", i, rank, last)
+		}
+		last = rank
+
+		rank, ok = rankBetween("", first)
+		if !ok || (first != "" && rank >= first) || !validateRank(rank) {
+			t.Fatalf("step %d: bad rank %q before %q", i, rank, first)
+		}
+		first = rank
+	}
+
+	if len(last) > 8 || len(first) > 8 {
+		t.Errorf("ranks should stay short, actual: %q %q", last, first)
+	}
+}
+
+func TestRanksBetween(t *testing.T) {
+	ranks, ok := ranksBetween("a", "b", 100)
+	if !ok || len(ranks) != 100 {
+		t.Fatalf("should return 100 ranks, actual: %d %v", len(ranks), ok)
+	}
+
+	prev := "a"
+	for _, rank := range ranks {
+		if rank <= prev || rank >= "b" || !validateRank(rank) {
+			t.Fatalf("ranks should be increasing between a and b: %v", ranks)
+		}
+		if len(rank) > 6 {
+			t.Errorf("rank %q is too long", rank)
+		}
+		prev = rank
+	}
+
+	_, ok = ranksBetween("b", "a", 2)
+	if ok {
+		t.Error("should not be ok")
+	}
+}
@@ -0,0 +1,298 @@
+package todo
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type recurrenceFreq int
+
+const (
+	freqDaily recurrenceFreq = iota + 1
+	freqWeekly
+	freqMonthly
+)
+
+var recurrenceFreqNames = map[string]recurrenceFreq{
+	"DAILY":   freqDaily,
+	"WEEKLY":  freqWeekly,
+	"MONTHLY": freqMonthly,
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+	"SU": time.Sunday,
+}
+
+// Recurrence rule, a subset of the RRULE of RFC 5545:
+// FREQ=DAILY|WEEKLY|MONTHLY, INTERVAL=n,
+// BYDAY=MO,TU,... for WEEKLY and BYMONTHDAY=1..31 or -1 (the last day)
+// for MONTHLY. Weeks start on Monday.
+// Occurrences are computed from the due date of the item
+type recurrence struct {
+	freq       recurrenceFreq
+	interval   int
+	byDay      []time.Weekday
+	byMonthDay []int
+}
+
+// stop looking for an occurrence after this number of periods,
+// e.g. BYMONTHDAY=31 with INTERVAL=2 from an even month never occurs
+const maxRecurrencePeriods = 1000
+
+func parseRecurrence(rule string) (recurrence, error) {
+	r := recurrence{interval: 1}
+	if len(rule) > 255 {
+		return r, errInvalidInput
+	}
+
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(strings.TrimPrefix(rule, "RRULE:"), ";") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 || seen[kv[0]] {
+			return r, errInvalidInput
+		}
+		seen[kv[0]] = true
+
+		switch kv[0] {
+		case "FREQ":
+			freq, ok := recurrenceFreqNames[kv[1]]
+			if !ok {
+				return r, errInvalidInput
+			}
+			r.freq = freq
+		case "INTERVAL":
+			n, err := strconv.Atoi(kv[1])
+			if err != nil || n < 1 || n > 999 {
+				return r, errInvalidInput
+			}
+			r.interval = n
+		case "BYDAY":
+			for _, name := range strings.Split(kv[1], ",") {
+				day, ok := weekdayNames[name]
+				if !ok {
+					return r, errInvalidInput
+				}
+				r.byDay = append(r.byDay, day)
+			}
+		case "BYMONTHDAY":
+			for _, s := range strings.Split(kv[1], ",") {
+				day, err := strconv.Atoi(s)
+				if err != nil || day == 0 || day < -1 || day > 31 {
+					return r, errInvalidInput
+				}
+				r.byMonthDay = append(r.byMonthDay, day)
+			}
+		default:
+			return r, errInvalidInput
+		}
+	}
+
+	if r.freq == 0 ||
+		(len(r.byDay) > 0 && r.freq != freqWeekly) ||
+		(len(r.byMonthDay) > 0 && r.freq != freqMonthly) {
+		return r, errInvalidInput
+	}
+	return r, nil
+}
+
+// return the canonical form of the rule, empty for no recurrence
+func normalizeRecurrence(rule string) (string, error) {
+	if rule == "" {
+		return "", nil
+	}
+
+	r, err := parseRecurrence(rule)
+	if err != nil {
+		return "", err
+	}
+	return r.String(), nil
+}
+
+// the canonical form of the rule
+func (r recurrence) String() string {
+	parts := make([]string, 0, 3)
+	for name, freq := range recurrenceFreqNames {
+		if freq == r.freq {
+			parts = append(parts, "FREQ="+name)
+		}
+	}
+	if r.interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.interval))
+	}
+	if len(r.byDay) > 0 {
+		names := make([]string, 0, len(r.byDay))
+		for _, day := range sortedWeekdays(r.byDay) {
+			for name, d := range weekdayNames {
+				if d == day {
+					names = append(names, name)
+				}
+			}
+		}
+		parts = append(parts, "BYDAY="+strings.Join(names, ","))
+	}
+	if len(r.byMonthDay) > 0 {
+		days := make([]string, 0, len(r.byMonthDay))
+		for _, day := range r.byMonthDay {
+			days = append(days, strconv.Itoa(day))
+		}
+		parts = append(parts, "BYMONTHDAY="+strings.Join(days, ","))
+	}
+	return strings.Join(parts, ";")
+}
+
+// days from Monday
+func weekdayOffset(day time.Weekday) int {
+	return (int(day) + 6) % 7
+}
+
+func sortedWeekdays(days []time.Weekday) []time.Weekday {
+	result := append([]time.Weekday(nil), days...)
+	sort.Slice(result, func(i, j int) bool {
+		return weekdayOffset(result[i]) < weekdayOffset(result[j])
+	})
+	return result
+}
+
+func daysIn(year int, month time.Month) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+// return the first occurrence strictly after due, keeping its time of day.
+// Months without the day of the rule are skipped, like RFC 5545 does
+func (r recurrence) next(due time.Time) (time.Time, bool) {
+	switch r.freq {
+	case freqDaily:
+		return due.AddDate(0, 0, r.interval), true
+
+	case freqWeekly:
+		if len(r.byDay) == 0 {
+			return due.AddDate(0, 0, 7*r.interval), true
+		}
+
+		days := sortedWeekdays(r.byDay)
+		for _, day := range days {
+			offset := weekdayOffset(day) - weekdayOffset(due.Weekday())
+			if offset > 0 {
+				return due.AddDate(0, 0, offset), true
+			}
+		}
+		monday := due.AddDate(0, 0, -weekdayOffset(due.Weekday()))
+		return monday.AddDate(0, 0, 7*r.interval+weekdayOffset(days[0])), true
+
+	case freqMonthly:
+		monthDays := r.byMonthDay
+		if len(monthDays) == 0 {
+			monthDays = []int{due.Day()}
+		}
+
+		for period := 0; period < maxRecurrencePeriods; period++ {
+			first := time.Date(due.Year(), due.Month()+time.Month(period*r.interval), 1,
+				due.Hour(), due.Minute(), due.Second(), 0, due.Location())
+			last := daysIn(first.Year(), first.Month())
+
+			candidates := make([]int, 0, len(monthDays))
+			for _, day := range monthDays {
+				if day == -1 {
+					day = last
+				}
+				if day <= last {
+					candidates = append(candidates, day)
+				}
+			}
+			sort.Ints(candidates)
+
+			for _, day := range candidates {
+				t := first.AddDate(0, 0, day-1)
+				if t.After(due) {
+					return t, true
+				}
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// the next occurrence of a completed item: same description and rule,
+// the due date and the reminder move to the next occurrence.
+// The rule applies to the due time in loc, the timezone of the account,
+// and to the date of an all-day due date
+func nextOccurrence(item todoItem, loc *time.Location) (todoItem, bool) {
+	if item.recurrence == "" || item.dueAt.IsZero() {
+		return todoItem{}, false
+	}
+
+	r, err := parseRecurrence(item.recurrence)
+	if err != nil {
+		return todoItem{}, false
+	}
+
+	due := item.dueAt.UTC()
+	if !item.dueAllDay {
+		due = due.In(loc)
+	}
+	due, ok := r.next(due)
+	if !ok {
+		return todoItem{}, false
+	}
+	due = due.UTC()
+
+	next := todoItem{
+		todoListID:  item.todoListID,
+		description: item.description,
+		dueAt:       due,
+		dueAllDay:   item.dueAllDay,
+		recurrence:  item.recurrence,
+		priority:    item.priority,
+		labelIDs:    item.labelIDs,
+		parentID:    item.parentID,
+	}
+	if !item.remindAt.IsZero() {
+		next.remindAt = item.remindAt.Add(due.Sub(item.dueAt))
+	}
+	return next, validateScheduleTime(next.dueAt) && validateScheduleTime(next.remindAt)
+}
+
+// move the item to its next occurrence without completing it
+func skipTodoItemOccurrence(
+	id, accountID, expectedVersion int,
+	loc *time.Location,
+	listGetter todoListGetter,
+	getter todoItemGetter,
+	updater todoItemUpdater,
+) (todoItem, error) {
+	item, err := getOwnedTodoItem(id, accountID, listGetter, getter)
+	if err != nil {
+		return item, err
+	}
+
+	if expectedVersion != 0 && expectedVersion != item.version {
+		return item, errVersionMismatch
+	}
+
+	next, ok := nextOccurrence(item, loc)
+	if !ok {
+		return item, errInvalidInput
+	}
+
+	changes := todoItemChanges{
+		setDueAt:    true,
+		dueAt:       next.dueAt,
+		dueAllDay:   next.dueAllDay,
+		setRemindAt: true,
+		remindAt:    next.remindAt,
+	}
+	updatedAt, err := updater(id, changes)
+	item.dueAt = next.dueAt
+	item.remindAt = next.remindAt
+	item.version++
+	item.updatedAt = updatedAt
+	return item, err
+}
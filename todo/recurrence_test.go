@@ -0,0 +1,153 @@
+package todo
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRecurrence(t *testing.T) {
+	cases := []struct {
+		rule, expected string
+	}{
+		{"FREQ=DAILY", "FREQ=DAILY"},
+		{"RRULE:FREQ=WEEKLY;BYDAY=FR,MO", "FREQ=WEEKLY;BYDAY=MO,FR"},
+		{"FREQ=MONTHLY;INTERVAL=2;BYMONTHDAY=-1", "FREQ=MONTHLY;INTERVAL=2;BYMONTHDAY=-1"},
+		{"INTERVAL=1;FREQ=DAILY", "FREQ=DAILY"},
+	}
+	for _, c := range cases {
+		result, err := normalizeRecurrence(c.rule)
+		if err != nil || result != c.expected {
+			t.Errorf("normalize %q: expected %q, actual %q %v", c.rule, c.expected, result, err)
+		}
+	}
+
+	invalid := []string{
+		"FREQ=YEARLY",
+		"FREQ=DAILY;BYDAY=MO",
+		"FREQ=WEEKLY;BYDAY=XX",
+		"FREQ=MONTHLY;BYMONTHDAY=32",
+		"FREQ=DAILY;INTERVAL=0",
+		"FREQ=DAILY;FREQ=WEEKLY",
+		"INTERVAL=2",
+	}
+	for _, rule := range invalid {
+		_, err := parseRecurrence(rule)
+		if err != errInvalidInput {
+			t.Errorf("%q should be invalid, actual: %v", rule, err)
+		}
+	}
+}
+
+func TestRecurrenceNext(t *testing.T) {
+	date := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 9, 30, 0, 0, time.UTC)
+	}
+
+	cases := []struct {
+		rule     string
+		due      time.Time
+		expected time.Time
+	}{
+		{"FREQ=DAILY;INTERVAL=3", date(2026, 10, 30), date(2026, 11, 2)},
+		{"FREQ=WEEKLY", date(2026, 10, 19), date(2026, 10, 26)},
+		// monday to friday, then the monday of the next week
+		{"FREQ=WEEKLY;BYDAY=MO,FR", date(2026, 10, 19), date(2026, 10, 23)},
+		{"FREQ=WEEKLY;BYDAY=MO,FR", date(2026, 10, 23), date(2026, 10, 26)},
+		{"FREQ=WEEKLY;INTERVAL=2;BYDAY=MO", date(2026, 10, 19), date(2026, 11, 2)},
+		// 31 is skipped in months without it
+		{"FREQ=MONTHLY", date(2026, 10, 31), date(2026, 12, 31)},
+		{"FREQ=MONTHLY;BYMONTHDAY=-1", date(2027, 1, 31), date(2027, 2, 28)},
+		{"FREQ=MONTHLY;BYMONTHDAY=1,15", date(2026, 10, 1), date(2026, 10, 15)},
+	}
+	for _, c := range cases {
+		r, err := parseRecurrence(c.rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		next, ok := r.next(c.due)
+		if !ok || !next.Equal(c.expected) {
+			t.Errorf("%s from %v: expected %v, actual %v", c.rule, c.due, c.expected, next)
+		}
+	}
+}
+
+func TestCompleteRecurringItem(t *testing.T) {
+	due := time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+	getter := func(id int) (todoList, error) {
+		return todoList{id: id, accountID: 10}, nil
+	}
+	selecter := func(todoListID int) ([]todoItem, error) {
+		return []todoItem{
+			{id: 1, todoListID: 1, description: "water plants",
+				dueAt: due, dueAllDay: true, remindAt: due.Add(8 * time.Hour),
+				recurrence: "FREQ=WEEKLY"},
+			{id: 2, todoListID: 1, description: "already done",
+				completed: true, dueAt: due, recurrence: "FREQ=DAILY"},
+		}, nil
+	}
+	updater := func(toBeCompleted, toBeActive []int, accountID int) error {
+		return nil
+	}
+	lastPosition := func(todoListID int) (string, error) {
+		return "i", nil
+	}
+	inserter := func(todoListID int, items []todoItem) ([]todoItem, error) {
+		return items, nil
+	}
+
+	created, _, err := updateTodoItemsCompleted(1, 10, []int{1, 2}, nil, false, nil, time.UTC,
+		getter, selecter, updater, lastPosition, inserter)
+	if err != nil || len(created) != 1 {
+		t.Fatalf("should create one occurrence, actual: %v %v", created, err)
+	}
+
+	next := created[0]
+	if next.description != "water plants" || !next.dueAllDay ||
+		!next.dueAt.Equal(due.AddDate(0, 0, 7)) ||
+		!next.remindAt.Equal(due.AddDate(0, 0, 7).Add(8*time.Hour)) ||
+		next.position <= "i" {
+		t.Errorf("unexpected next occurrence: %+v", next)
+	}
+}
+
+func TestNextOccurrenceInTimezone(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Skip(err)
+	}
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	cases := []struct {
+		rule     string
+		loc      *time.Location
+		due      time.Time
+		expected time.Time
+	}{
+		// Monday 8am in Tokyo is Sunday in UTC
+		{"FREQ=WEEKLY;BYDAY=MO", tokyo,
+			time.Date(2026, 10, 18, 23, 0, 0, 0, time.UTC),
+			time.Date(2026, 10, 25, 23, 0, 0, 0, time.UTC)},
+		// 9am in New York across the end of daylight saving time
+		{"FREQ=DAILY", newYork,
+			time.Date(2026, 10, 31, 13, 0, 0, 0, time.UTC),
+			time.Date(2026, 11, 1, 14, 0, 0, 0, time.UTC)},
+	}
+	for _, c := range cases {
+		item := todoItem{description: "standup", dueAt: c.due, recurrence: c.rule}
+		next, ok := nextOccurrence(item, c.loc)
+		if !ok || !next.dueAt.Equal(c.expected) || next.dueAt.Location() != time.UTC {
+			t.Errorf("%s from %v: expected %v, actual %v", c.rule, c.due, c.expected, next.dueAt)
+		}
+	}
+
+	// an all-day due date keeps its date
+	item := todoItem{description: "standup", recurrence: "FREQ=WEEKLY;BYDAY=MO",
+		dueAt: time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC), dueAllDay: true}
+	next, ok := nextOccurrence(item, newYork)
+	if !ok || !next.dueAt.Equal(time.Date(2026, 10, 26, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("should be the next Monday, actual: %v", next.dueAt)
+	}
+}
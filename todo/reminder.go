@@ -0,0 +1,222 @@
+package todo
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/jmoiron/sqlx"
+)
+
+// Reminder : a todo item whose reminder time is reached
+type Reminder struct {
+	ItemID      int       `json:"item_id"`
+	TodoListID  int       `json:"todo_list_id"`
+	AccountID   int       `json:"account_id"`
+	Username    string    `json:"username"`
+	Description string    `json:"description"`
+	DueAt       string    `json:"due_at,omitempty"`
+	RemindAt    time.Time `json:"remind_at"`
+}
+
+// Notifier : deliver reminders to the account owning the item
+type Notifier interface {
+	Notify(ctx context.Context, reminder Reminder) error
+}
+
+// claim at most limit reminders due at now and not already sent,
+// a claim is held by owner until now + lease.
+// Reminders claimed by another owner are skipped until the lease expires
+type dueRemindersClaimer = func(owner string, now time.Time, lease time.Duration, limit int) ([]Reminder, error)
+
+// do nothing if the claim of owner is lost or its lease expired at sentAt
+type reminderSentMarker = func(itemID int, owner string, sentAt time.Time) error
+
+// return the number of reminders sent, a reminder that failed to be
+// delivered is retried by any instance after its lease expired.
+// A reminder is delivered only if the notifier can return before the
+// lease expires, so another instance never claims it while it is sent
+func deliverDueReminders(
+	ctx context.Context,
+	owner string, now time.Time, lease time.Duration, limit int,
+	claimer dueRemindersClaimer,
+	notifier Notifier,
+	marker reminderSentMarker,
+) (int, error) {
+	reminders, err := claimer(owner, now, lease, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	// the claimer truncates now to the second
+	deadline := now.Truncate(time.Second).Add(lease - reminderLeaseMargin)
+
+	sent := 0
+	for i, reminder := range reminders {
+		if time.Now().Add(reminderNotifyTimeout).After(deadline) {
+			// the remaining reminders are claimed again after the lease
+			glog.Warningf("lease of %d reminders expiring", len(reminders)-i)
+			break
+		}
+
+		notifyCtx, cancel := context.WithDeadline(ctx, deadline)
+		err := notifier.Notify(notifyCtx, reminder)
+		cancel()
+		if err != nil {
+			glog.Errorf("reminder of item %d: %v", reminder.ItemID, err)
+			continue
+		}
+
+		err = marker(reminder.ItemID, owner, time.Now())
+		if err != nil {
+			return sent, err
+		}
+		sent++
+	}
+	return sent, nil
+}
+
+// WebhookNotifier : POST reminders as JSON to an URL
+type WebhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhookNotifier : create a new webhook notifier
+func NewWebhookNotifier(url string) *WebhookNotifier {
+	return &WebhookNotifier{
+		url:    url,
+		client: &http.Client{Timeout: reminderNotifyTimeout},
+	}
+}
+
+// Notify : any response other than 2xx is an error
+func (n *WebhookNotifier) Notify(ctx context.Context, reminder Reminder) error {
+	body, err := json.Marshal(reminder)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded %s", res.Status)
+	}
+	return nil
+}
+
+// LogNotifier : write reminders as JSON lines, for local testing
+type LogNotifier struct {
+	mutex sync.Mutex
+	out   io.Writer
+}
+
+// NewLogNotifier : create a new log notifier writing to out
+func NewLogNotifier(out io.Writer) *LogNotifier {
+	return &LogNotifier{out: out}
+}
+
+// Notify : write one line per reminder
+func (n *LogNotifier) Notify(ctx context.Context, reminder Reminder) error {
+	line, err := json.Marshal(reminder)
+	if err != nil {
+		return err
+	}
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	_, err = n.out.Write(append(line, '\n'))
+	return err
+}
+
+const (
+	reminderLease     = time.Minute
+	reminderBatchSize = 100
+	// the longest a notifier takes to deliver a reminder
+	reminderNotifyTimeout = 10 * time.Second
+	// a reminder is marked sent at least that long before its lease expires
+	reminderLeaseMargin = 5 * time.Second
+)
+
+// ReminderScheduler : poll the database for due reminders
+// and deliver them, many instances can run at the same time
+type ReminderScheduler struct {
+	repo     *repository
+	notifier Notifier
+	interval time.Duration
+	owner    string
+}
+
+// NewReminderScheduler : create a new scheduler polling every interval
+func NewReminderScheduler(db *sqlx.DB, notifier Notifier, interval time.Duration) *ReminderScheduler {
+	return &ReminderScheduler{
+		repo:     newRepository(db),
+		notifier: notifier,
+		interval: interval,
+		owner:    newReminderOwner(),
+	}
+}
+
+// identify an instance holding claims
+func newReminderOwner() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+
+	suffix := make([]byte, 4)
+	_, err = rand.Read(suffix)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	return fmt.Sprintf("%.40s-%d-%s", host, os.Getpid(), hex.EncodeToString(suffix))
+}
+
+// Run : deliver the due reminders until ctx is done
+func (s *ReminderScheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			sent, err := deliverDueReminders(ctx,
+				s.owner, time.Now(), reminderLease, reminderBatchSize,
+				s.repo.claimDueReminders(ctx),
+				s.notifier,
+				s.repo.markReminderSent(ctx),
+			)
+			if err != nil {
+				glog.Error(err)
+			}
+			// a full batch means there may be more due reminders
+			if err != nil || sent < reminderBatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package todo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+type notifierFunc func(ctx context.Context, reminder Reminder) error
+
+func (f notifierFunc) Notify(ctx context.Context, reminder Reminder) error {
+	return f(ctx, reminder)
+}
+
+func TestDeliverDueReminders(t *testing.T) {
+	now := time.Now()
+
+	claimedLimit := 0
+	claimer := func(owner string, at time.Time, lease time.Duration, limit int) ([]Reminder, error) {
+		claimedLimit = limit
+		return []Reminder{{ItemID: 1}, {ItemID: 2}, {ItemID: 3}}, nil
+	}
+	notifier := notifierFunc(func(ctx context.Context, reminder Reminder) error {
+		if reminder.ItemID == 2 {
+			return errors.New("unreachable")
+		}
+		return nil
+	})
+	marked := make([]int, 0)
+	marker := func(itemID int, owner string, sentAt time.Time) error {
+		if owner != "a" {
+			t.Errorf("should be marked by the owner of the claim, actual: %s", owner)
+		}
+		marked = append(marked, itemID)
+		return nil
+	}
+
+	sent, err := deliverDueReminders(context.Background(),
+		"a", now, time.Minute, 10, claimer, notifier, marker)
+	if err != nil || sent != 2 || claimedLimit != 10 {
+		t.Errorf("should send 2 reminders, actual: %d %v", sent, err)
+	}
+	if len(marked) != 2 || marked[0] != 1 || marked[1] != 3 {
+		t.Errorf("failed reminder should not be marked sent, actual: %v", marked)
+	}
+
+	marked = marked[:0]
+	sent, err = deliverDueReminders(context.Background(),
+		"a", now.Add(-time.Minute), time.Minute, 10, claimer, notifier, marker)
+	if err != nil || sent != 0 || len(marked) != 0 {
+		t.Errorf("should not deliver after the lease, actual: %d %v %v", sent, err, marked)
+	}
+}
+
+func TestLogNotifier(t *testing.T) {
+	var out bytes.Buffer
+	notifier := NewLogNotifier(&out)
+
+	err := notifier.Notify(context.Background(), Reminder{
+		ItemID:      5,
+		Description: "call the bank",
+		DueAt:       "2026-10-19",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	line := out.String()
+	if !strings.HasSuffix(line, "\n") ||
+		!strings.Contains(line, `"item_id":5`) ||
+		!strings.Contains(line, `"due_at":"2026-10-19"`) {
+		t.Errorf("should be a JSON line, actual: %q", line)
+	}
+}
@@ -2,6 +2,10 @@ package todo
 
 import (
 	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/jmoiron/sqlx"
@@ -45,17 +49,17 @@ func (repo *repository) saveAccount(ctx context.Context) accountSaver {
 	}
 }
 
-func (repo *repository) saveTodoList(ctx context.Context) todoListSaver {
-	return func(accountID int, name string) (int, time.Time, error) {
+func (repo *repository) saveTodoList(ctx context.Context, tx *sqlx.Tx) todoListSaver {
+	return func(accountID int, name string, position string) (int, time.Time, error) {
 		now := time.Now()
 		query := repo.db.Rebind(`
             INSERT INTO todo_list (
-                name, account_id,
+                name, account_id, position,
                 created_at, updated_at)
-            VALUES (?, ?, ?, ?)
+            VALUES (?, ?, ?, ?, ?)
             `)
 
-		res, err := repo.db.ExecContext(ctx, query, name, accountID, now, now)
+		res, err := tx.ExecContext(ctx, query, name, accountID, position, now, now)
 		if err != nil {
 			return 0, now, err
 		}
@@ -65,58 +69,202 @@ func (repo *repository) saveTodoList(ctx context.Context) todoListSaver {
 	}
 }
 
+// locks the end of the account's lists so that concurrent appends are serialized
+func (repo *repository) getLastTodoListPosition(
+	ctx context.Context, tx *sqlx.Tx,
+) todoListLastPositionGetter {
+	return func(accountID int) (string, error) {
+		var position string
+		query := repo.db.Rebind(`
+            SELECT COALESCE(MAX(position), '') FROM todo_list
+            WHERE account_id = ? FOR UPDATE`)
+		err := tx.GetContext(ctx, &position, query, accountID)
+		return position, err
+	}
+}
+
+func (repo *repository) getNeighbourTodoListPosition(
+	ctx context.Context, tx *sqlx.Tx,
+) todoListNeighbourGetter {
+	return func(accountID int, position string, after bool) (string, error) {
+		query := `
+            SELECT COALESCE(MIN(position), '') FROM todo_list
+            WHERE account_id = ? AND position > ?`
+		if !after {
+			query = `
+            SELECT COALESCE(MAX(position), '') FROM todo_list
+            WHERE account_id = ? AND position < ?`
+		}
+
+		var result string
+		err := tx.GetContext(ctx, &result, repo.db.Rebind(query), accountID, position)
+		return result, err
+	}
+}
+
+func (repo *repository) updateTodoListPosition(
+	ctx context.Context, tx *sqlx.Tx,
+) todoListPositionUpdater {
+	return func(id int, position string) (time.Time, error) {
+		now := time.Now()
+		query := repo.db.Rebind(`
+            UPDATE todo_list SET position = ?, updated_at = ?,
+                version = version + 1
+            WHERE id = ?`)
+		_, err := tx.ExecContext(ctx, query, position, now, id)
+		return now, err
+	}
+}
+
+func (repo *repository) getAccountTimezone(
+	ctx context.Context, q sqlx.QueryerContext,
+) accountTimezoneGetter {
+	return func(accountID int) (string, error) {
+		var timezone string
+		query := repo.db.Rebind(`SELECT timezone FROM account WHERE id = ?`)
+		err := sqlx.GetContext(ctx, q, &timezone, query, accountID)
+		return timezone, err
+	}
+}
+
+func (repo *repository) updateAccountTimezone(
+	ctx context.Context, tx *sqlx.Tx,
+) accountTimezoneUpdater {
+	return func(accountID int, timezone string) error {
+		query := repo.db.Rebind(`
+            UPDATE account SET timezone = ? WHERE id = ?`)
+		_, err := tx.ExecContext(ctx, query, timezone, accountID)
+		return err
+	}
+}
+
+// the collation of the name ignores case
+func (repo *repository) getTodoListByName(
+	ctx context.Context, tx *sqlx.Tx,
+) todoListByNameGetter {
+	return func(accountID int, name string) (todoList, error) {
+		r := todoListEntity{}
+		query := repo.db.Rebind(`
+            SELECT id, name, account_id, position, folder_id,
+                version, created_at, updated_at
+            FROM todo_list WHERE account_id = ? AND name = ?
+            ORDER BY position, id LIMIT 1`)
+		err := tx.GetContext(ctx, &r, query, accountID, name)
+		return r.toTodoList(), err
+	}
+}
+
+func (repo *repository) getFirstTodoList(
+	ctx context.Context, tx *sqlx.Tx,
+) firstTodoListGetter {
+	return func(accountID int) (todoList, error) {
+		r := todoListEntity{}
+		query := repo.db.Rebind(`
+            SELECT id, name, account_id, position, folder_id,
+                version, created_at, updated_at
+            FROM todo_list WHERE account_id = ?
+            ORDER BY position, id LIMIT 1`)
+		err := tx.GetContext(ctx, &r, query, accountID)
+		return r.toTodoList(), err
+	}
+}
+
+// NULL for the zero id
+func nullableID(id int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(id), Valid: id != 0}
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// the text matches literally in a LIKE pattern
+func escapeLike(text string) string {
+	return likeEscaper.Replace(text)
+}
+
+// NULL for the zero time
+func nullableTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+type todoListEntity struct {
+	ID        int           `db:"id"`
+	Name      string        `db:"name"`
+	AccountID int           `db:"account_id"`
+	Position  string        `db:"position"`
+	FolderID  sql.NullInt64 `db:"folder_id"`
+	Version   int           `db:"version"`
+	CreatedAt time.Time     `db:"created_at"`
+	UpdatedAt time.Time     `db:"updated_at"`
+}
+
+func (e todoListEntity) toTodoList() todoList {
+	return todoList{
+		id:        e.ID,
+		accountID: e.AccountID,
+		name:      e.Name,
+		position:  e.Position,
+		folderID:  int(e.FolderID.Int64),
+		version:   e.Version,
+		createdAt: e.CreatedAt,
+		updatedAt: e.UpdatedAt,
+	}
+}
+
 func (repo *repository) getTodoList(ctx context.Context, tx *sqlx.Tx) todoListGetter {
 	return func(id int) (todoList, error) {
-		type Result struct {
-			ID        int       `db:"id"`
-			Name      string    `db:"name"`
-			AccountID int       `db:"account_id"`
-			CreatedAt time.Time `db:"created_at"`
-			UpdatedAt time.Time `db:"updated_at"`
-		}
-		r := Result{}
+		r := todoListEntity{}
 
 		query := repo.db.Rebind(`
-            SELECT id, name, account_id,
-                created_at, updated_at
-            FROM todo_list WHERE id = ?
+            SELECT id, name, account_id, position, folder_id,
+                version, created_at, updated_at
+            FROM todo_list WHERE id = ? FOR UPDATE
             `)
 
 		err := tx.GetContext(ctx, &r, query, id)
-		return todoList{
-			id:        r.ID,
-			accountID: r.AccountID,
-			name:      r.Name,
-			createdAt: r.CreatedAt,
-			updatedAt: r.UpdatedAt,
-		}, err
+		return r.toTodoList(), err
 	}
 }
 
 func (repo *repository) getTodoListNoTx(ctx context.Context) todoListGetter {
 	return func(id int) (todoList, error) {
-		type Result struct {
-			ID        int       `db:"id"`
-			Name      string    `db:"name"`
-			AccountID int       `db:"account_id"`
-			CreatedAt time.Time `db:"created_at"`
-			UpdatedAt time.Time `db:"updated_at"`
-		}
-		r := Result{}
+		r := todoListEntity{}
 
 		query := repo.db.Rebind(`
-            SELECT id, name, account_id,
-                created_at, updated_at
+            SELECT id, name, account_id, position, folder_id,
+                version, created_at, updated_at
             FROM todo_list WHERE id = ?
             `)
 
 		err := repo.db.GetContext(ctx, &r, query, id)
-		return todoList{
-			id:        r.ID,
-			accountID: r.AccountID,
-			name:      r.Name,
-			createdAt: r.CreatedAt,
-			updatedAt: r.UpdatedAt,
+		return r.toTodoList(), err
+	}
+}
+
+func (repo *repository) getTodoListStatsNoTx(ctx context.Context) todoListStatsGetter {
+	return func(todoListID int) (todoListStats, error) {
+		type Result struct {
+			ActiveCount    int       `db:"active_count"`
+			CompletedCount int       `db:"completed_count"`
+			LastActivityAt time.Time `db:"last_activity_at"`
+		}
+		r := Result{}
+
+		query := repo.db.Rebind(`
+            SELECT
+                COALESCE(SUM(i.completed = FALSE), 0) AS active_count,
+                COALESCE(SUM(i.completed = TRUE), 0) AS completed_count,
+                GREATEST(l.updated_at,
+                    COALESCE(MAX(i.updated_at), l.updated_at)) AS last_activity_at
+            FROM todo_list l
+            LEFT JOIN todo_item i ON i.todo_list_id = l.id
+            WHERE l.id = ?
+            GROUP BY l.id, l.updated_at`)
+
+		err := repo.db.GetContext(ctx, &r, query, todoListID)
+		return todoListStats{
+			activeCount:    r.ActiveCount,
+			completedCount: r.CompletedCount,
+			lastActivityAt: r.LastActivityAt,
 		}, err
 	}
 }
@@ -125,42 +273,43 @@ func (repo *repository) updateTodoList(ctx context.Context, tx *sqlx.Tx) todoLis
 	return func(id int, name string) (time.Time, error) {
 		now := time.Now()
 		query := repo.db.Rebind(`
-            UPDATE todo_list SET name = ?, updated_at = ? WHERE id = ?`)
+            UPDATE todo_list SET name = ?, updated_at = ?,
+                version = version + 1
+            WHERE id = ?`)
 		_, err := tx.ExecContext(ctx, query, name, now, id)
 		return now, err
 	}
 }
 
-func (repo *repository) getTodoListsByAccount(ctx context.Context) todoListsByAccountGetter {
-	return func(accountID int) ([]todoList, error) {
-		type Todo struct {
-			ID        int       `db:"id"`
-			AccountID int       `db:"account_id"`
-			Name      string    `db:"name"`
-			CreatedAt time.Time `db:"created_at"`
-			UpdatedAt time.Time `db:"updated_at"`
-		}
-
-		todos := make([]Todo, 0)
+func (repo *repository) selectTodoListsPageNoTx(ctx context.Context) todoListPageSelecter {
+	return func(accountID int, after []string, limit int) ([]todoList, error) {
+		todos := make([]todoListEntity, 0)
 		result := make([]todoList, 0)
 
+		where := "account_id = ?"
+		args := []interface{}{accountID}
+		if after != nil {
+			if len(after) != 2 {
+				return result, errInvalidPageToken
+			}
+			where += " AND (position > ? OR (position = ? AND id > ?))"
+			args = append(args, after[0], after[0], after[1])
+		}
+		args = append(args, limit)
+
 		query := repo.db.Rebind(`
-            SELECT id, account_id, name, created_at, updated_at
-            FROM todo_list WHERE account_id = ?`)
+            SELECT id, account_id, name, position, folder_id,
+                version, created_at, updated_at
+            FROM todo_list WHERE ` + where + `
+            ORDER BY position, id LIMIT ?`)
 
-		err := repo.db.SelectContext(ctx, &todos, query, accountID)
+		err := repo.db.SelectContext(ctx, &todos, query, args...)
 		if err != nil {
 			return result, err
 		}
 
 		for _, t := range todos {
-			result = append(result, todoList{
-				id:        t.ID,
-				accountID: t.AccountID,
-				name:      t.Name,
-				createdAt: t.CreatedAt,
-				updatedAt: t.UpdatedAt,
-			})
+			result = append(result, t.toTodoList())
 		}
 
 		return result, nil
@@ -176,97 +325,545 @@ func (repo *repository) deleteTodoList(ctx context.Context, tx *sqlx.Tx) todoLis
 	}
 }
 
-func (repo *repository) createTodoItem(ctx context.Context, tx *sqlx.Tx) todoItemSaver {
-	return func(todoListID int, description string) (int, time.Time, error) {
+func (repo *repository) countTodoListItems(
+	ctx context.Context, tx *sqlx.Tx,
+) todoListItemCounter {
+	return func(todoListID int) (int, error) {
+		var count int
+		query := repo.db.Rebind(`
+            SELECT COUNT(*) FROM todo_item WHERE todo_list_id = ?`)
+		err := tx.GetContext(ctx, &count, query, todoListID)
+		return count, err
+	}
+}
+
+func (repo *repository) deleteTodoListItems(
+	ctx context.Context, tx *sqlx.Tx,
+) todoListItemsDeleter {
+	return func(todoListID int) (int, error) {
+		// the rows deleted by the cascade on parent_item_id are not
+		// counted as affected, the items are counted first
+		var count int
+		query := repo.db.Rebind(`
+            SELECT COUNT(*) FROM todo_item WHERE todo_list_id = ?
+            FOR UPDATE`)
+		err := tx.GetContext(ctx, &count, query, todoListID)
+		if err != nil {
+			return 0, err
+		}
+
+		query = repo.db.Rebind(`
+            DELETE FROM todo_item WHERE todo_list_id = ?`)
+		_, err = tx.ExecContext(ctx, query, todoListID)
+		return count, err
+	}
+}
+
+// rows inserted by one statement, MySQL allows 65535 placeholders
+const insertBatchSize = 500
+
+// the items are inserted by batches of insertBatchSize rows
+func (repo *repository) insertTodoItems(ctx context.Context, tx *sqlx.Tx) todoItemsInserter {
+	return func(todoListID int, items []todoItem) ([]todoItem, error) {
+		result := make([]todoItem, 0, len(items))
+		now := time.Now()
+		for start := 0; start < len(items); start += insertBatchSize {
+			end := start + insertBatchSize
+			if end > len(items) {
+				end = len(items)
+			}
+
+			inserted, err := repo.insertTodoItemsBatch(ctx, tx, todoListID, items[start:end], now)
+			if err != nil {
+				return result, err
+			}
+			result = append(result, inserted...)
+		}
+
+		labels := make([]string, 0)
+		labelArgs := make([]interface{}, 0)
+		for i, item := range result {
+			for _, labelID := range item.labelIDs {
+				labels = append(labels, "(?, ?)")
+				labelArgs = append(labelArgs, item.id, labelID)
+			}
+
+			if len(labels) > 0 && (len(labels) >= insertBatchSize || i == len(result)-1) {
+				query := repo.db.Rebind(`
+                    INSERT INTO todo_item_label(todo_item_id, label_id)
+                    VALUES ` + strings.Join(labels, ", "))
+				_, err := tx.ExecContext(ctx, query, labelArgs...)
+				if err != nil {
+					return result, err
+				}
+				labels = labels[:0]
+				labelArgs = labelArgs[:0]
+			}
+		}
+		return result, nil
+	}
+}
+
+// the ids of the rows are read back: they are not always consecutive,
+// e.g. with innodb_autoinc_lock_mode = 2. They increase in the order of
+// the rows, and the rows of the other transactions are not visible
+func (repo *repository) insertTodoItemsBatch(
+	ctx context.Context, tx *sqlx.Tx,
+	todoListID int, items []todoItem, now time.Time,
+) ([]todoItem, error) {
+	values := make([]string, 0, len(items))
+	args := make([]interface{}, 0, 14*len(items))
+	for _, item := range items {
+		values = append(values, "(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
+		args = append(args, todoListID, item.description,
+			item.completed, item.position,
+			nullableTime(item.dueAt), item.dueAllDay,
+			nullableTime(item.remindAt), item.recurrence,
+			item.priority, nullableID(item.parentID),
+			nullableTime(item.completedAt), nullableID(item.completedBy),
+			now, now)
+	}
+
+	query := repo.db.Rebind(`
+        INSERT INTO todo_item(
+            todo_list_id, description,
+            completed, position,
+            due_at, due_all_day, remind_at, recurrence,
+            priority, parent_item_id,
+            completed_at, completed_by_account_id,
+            created_at, updated_at)
+        VALUES ` + strings.Join(values, ", "))
+	res, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	firstID, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int, 0, len(items))
+	query = repo.db.Rebind(`
+        SELECT id FROM todo_item
+        WHERE id >= ? AND todo_list_id = ?
+        ORDER BY id LIMIT ?`)
+	err = tx.SelectContext(ctx, &ids, query, firstID, todoListID, len(items))
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) != len(items) {
+		return nil, fmt.Errorf("inserted %d todo items, found %d", len(items), len(ids))
+	}
+
+	result := make([]todoItem, 0, len(items))
+	for i, item := range items {
+		item.id = ids[i]
+		item.todoListID = todoListID
+		item.version = 1
+		item.createdAt = now
+		item.updatedAt = now
+		result = append(result, item)
+	}
+	return result, nil
+}
+
+// locks the end of the list so that concurrent appends are serialized
+func (repo *repository) getLastTodoItemPosition(
+	ctx context.Context, tx *sqlx.Tx,
+) todoItemLastPositionGetter {
+	return func(todoListID int) (string, error) {
+		var position string
+		query := repo.db.Rebind(`
+            SELECT COALESCE(MAX(position), '') FROM todo_item
+            WHERE todo_list_id = ? FOR UPDATE`)
+		err := tx.GetContext(ctx, &position, query, todoListID)
+		return position, err
+	}
+}
+
+func (repo *repository) getNeighbourTodoItemPosition(
+	ctx context.Context, tx *sqlx.Tx,
+) todoItemNeighbourGetter {
+	return func(todoListID int, position string, after bool) (string, error) {
+		query := `
+            SELECT COALESCE(MIN(position), '') FROM todo_item
+            WHERE todo_list_id = ? AND position > ?`
+		if !after {
+			query = `
+            SELECT COALESCE(MAX(position), '') FROM todo_item
+            WHERE todo_list_id = ? AND position < ?`
+		}
+
+		var result string
+		err := tx.GetContext(ctx, &result, repo.db.Rebind(query), todoListID, position)
+		return result, err
+	}
+}
+
+func (repo *repository) updateTodoItemPosition(
+	ctx context.Context, tx *sqlx.Tx,
+) todoItemPositionUpdater {
+	return func(id int, position string) (time.Time, error) {
 		now := time.Now()
+		query := repo.db.Rebind(`
+            UPDATE todo_item SET position = ?, version = version + 1,
+                updated_at = ?
+            WHERE id = ?`)
+		_, err := tx.ExecContext(ctx, query, position, now, id)
+		return now, err
+	}
+}
 
+func (repo *repository) moveTodoItems(ctx context.Context, tx *sqlx.Tx) todoItemsMover {
+	return func(items []todoItem) (time.Time, error) {
+		now := time.Now()
 		query := repo.db.Rebind(`
-            INSERT INTO todo_item(
-                todo_list_id, description,
-                completed, created_at)
-            VALUES (?, ?, FALSE, ?)`)
-		res, err := tx.ExecContext(ctx, query, todoListID, description, now)
+            UPDATE todo_item
+            SET todo_list_id = ?, position = ?, parent_item_id = ?,
+                version = version + 1, updated_at = ?
+            WHERE id = ?`)
+		for _, item := range items {
+			_, err := tx.ExecContext(ctx, query, item.todoListID,
+				item.position, nullableID(item.parentID), now, item.id)
+			if err != nil {
+				return now, err
+			}
+		}
+		return now, nil
+	}
+}
+
+func (repo *repository) selectTodoItemChildren(
+	ctx context.Context, tx *sqlx.Tx,
+) todoItemChildrenSelecter {
+	return func(parentIDs []int) ([]todoItem, error) {
+		entities := make([]todoItemEntity, 0)
+		if len(parentIDs) == 0 {
+			return []todoItem{}, nil
+		}
+
+		query, args, err := sqlx.In(`
+            SELECT `+todoItemColumns+`
+            FROM todo_item WHERE parent_item_id IN (?)
+            ORDER BY position, id FOR UPDATE`, parentIDs)
 		if err != nil {
-			return 0, now, err
+			return nil, err
 		}
 
-		id, err := res.LastInsertId()
-		return int(id), now, err
+		err = tx.SelectContext(ctx, &entities, repo.db.Rebind(query), args...)
+		if err != nil {
+			return nil, err
+		}
+		return repo.withTodoItemLabels(ctx, tx, entities)
 	}
 }
 
-func (repo *repository) selectTodoItemsNoTx(ctx context.Context) todoItemSelecter {
-	return func(todoListID int) ([]todoItem, error) {
-		type Entity struct {
-			ID          int       `db:"id"`
-			Description string    `db:"description"`
-			Completed   bool      `db:"completed"`
-			CreatedAt   time.Time `db:"created_at"`
+const todoItemColumns = `id, todo_list_id, description, completed,
+    position, version, due_at, due_all_day, remind_at, recurrence,
+    priority, parent_item_id, completed_at, completed_by_account_id,
+    created_at, updated_at`
+
+// todoItemColumns prefixed with the alias of todo_item in a join
+func todoItemColumnsOf(alias string) string {
+	columns := strings.Split(todoItemColumns, ",")
+	for i, c := range columns {
+		columns[i] = alias + "." + strings.TrimSpace(c)
+	}
+	return strings.Join(columns, ", ")
+}
+
+type todoItemEntity struct {
+	ID          int           `db:"id"`
+	TodoListID  int           `db:"todo_list_id"`
+	Description string        `db:"description"`
+	Completed   bool          `db:"completed"`
+	Position    string        `db:"position"`
+	Version     int           `db:"version"`
+	DueAt       sql.NullTime  `db:"due_at"`
+	DueAllDay   bool          `db:"due_all_day"`
+	RemindAt    sql.NullTime  `db:"remind_at"`
+	Recurrence  string        `db:"recurrence"`
+	Priority    int           `db:"priority"`
+	ParentID    sql.NullInt64 `db:"parent_item_id"`
+	CompletedAt sql.NullTime  `db:"completed_at"`
+	CompletedBy sql.NullInt64 `db:"completed_by_account_id"`
+	CreatedAt   time.Time     `db:"created_at"`
+	UpdatedAt   time.Time     `db:"updated_at"`
+}
+
+func (e todoItemEntity) toTodoItem() todoItem {
+	return todoItem{
+		id:          e.ID,
+		todoListID:  e.TodoListID,
+		description: e.Description,
+		completed:   e.Completed,
+		position:    e.Position,
+		version:     e.Version,
+		dueAt:       e.DueAt.Time,
+		dueAllDay:   e.DueAllDay,
+		remindAt:    e.RemindAt.Time,
+		recurrence:  e.Recurrence,
+		priority:    e.Priority,
+		parentID:    int(e.ParentID.Int64),
+		completedAt: e.CompletedAt.Time,
+		completedBy: int(e.CompletedBy.Int64),
+		createdAt:   e.CreatedAt,
+		updatedAt:   e.UpdatedAt,
+	}
+}
+
+func (repo *repository) getTodoItem(ctx context.Context, tx *sqlx.Tx) todoItemGetter {
+	return func(id int) (todoItem, error) {
+		e := todoItemEntity{}
+
+		query := repo.db.Rebind(`
+            SELECT ` + todoItemColumns + `
+            FROM todo_item WHERE id = ? FOR UPDATE`)
+		err := tx.GetContext(ctx, &e, query, id)
+		if err != nil {
+			return todoItem{}, err
 		}
-		entities := make([]Entity, 0)
-		result := make([]todoItem, 0)
+
+		items, err := repo.withTodoItemLabels(ctx, tx, []todoItemEntity{e})
+		if err != nil {
+			return todoItem{}, err
+		}
+		return items[0], nil
+	}
+}
+
+func (repo *repository) getTodoItemNoTx(ctx context.Context) todoItemGetter {
+	return func(id int) (todoItem, error) {
+		e := todoItemEntity{}
 
 		query := repo.db.Rebind(`
-            SELECT id, description, completed, created_at
-            FROM todo_item WHERE todo_list_id = ?`)
-		err := repo.db.SelectContext(ctx, &entities, query, todoListID)
+            SELECT ` + todoItemColumns + `
+            FROM todo_item WHERE id = ?`)
+		err := repo.db.GetContext(ctx, &e, query, id)
 		if err != nil {
-			return result, err
+			return todoItem{}, err
 		}
 
-		for _, e := range entities {
-			result = append(result, todoItem{
-				id:          e.ID,
-				todoListID:  todoListID,
-				description: e.Description,
-				completed:   e.Completed,
-				createdAt:   e.CreatedAt,
-			})
+		items, err := repo.withTodoItemLabels(ctx, repo.db, []todoItemEntity{e})
+		if err != nil {
+			return todoItem{}, err
 		}
+		return items[0], nil
+	}
+}
+
+func (repo *repository) getTodoItemNotesNoTx(ctx context.Context) todoItemNotesGetter {
+	return func(itemID int) (string, error) {
+		var notes string
+		query := repo.db.Rebind(`
+            SELECT body FROM todo_item_note WHERE todo_item_id = ?`)
+		err := repo.db.GetContext(ctx, &notes, query, itemID)
+		if err == sql.ErrNoRows {
+			return "", nil
+		}
+		return notes, err
+	}
+}
+
+func (repo *repository) saveTodoItemNotes(ctx context.Context, tx *sqlx.Tx) todoItemNotesSaver {
+	return func(itemID int, notes string) error {
+		if notes == "" {
+			query := repo.db.Rebind(`
+                DELETE FROM todo_item_note WHERE todo_item_id = ?`)
+			_, err := tx.ExecContext(ctx, query, itemID)
+			return err
+		}
+
+		query := repo.db.Rebind(`
+            INSERT INTO todo_item_note(todo_item_id, body, updated_at)
+            VALUES (?, ?, ?)
+            ON DUPLICATE KEY UPDATE
+                body = VALUES(body), updated_at = VALUES(updated_at)`)
+		_, err := tx.ExecContext(ctx, query, itemID, notes, time.Now())
+		return err
+	}
+}
+
+// convert the entities to items with their labels
+func (repo *repository) withTodoItemLabels(
+	ctx context.Context, q sqlx.QueryerContext,
+	entities []todoItemEntity,
+) ([]todoItem, error) {
+	result := make([]todoItem, 0, len(entities))
+	if len(entities) == 0 {
 		return result, nil
 	}
+
+	ids := make([]int, 0, len(entities))
+	for _, e := range entities {
+		ids = append(ids, e.ID)
+	}
+
+	query, args, err := sqlx.In(`
+        SELECT todo_item_id, label_id
+        FROM todo_item_label WHERE todo_item_id IN (?)
+        ORDER BY label_id`, ids)
+	if err != nil {
+		return result, err
+	}
+
+	type itemLabel struct {
+		ItemID  int `db:"todo_item_id"`
+		LabelID int `db:"label_id"`
+	}
+	rows := make([]itemLabel, 0)
+	err = sqlx.SelectContext(ctx, q, &rows, repo.db.Rebind(query), args...)
+	if err != nil {
+		return result, err
+	}
+
+	labels := make(map[int][]int)
+	for _, r := range rows {
+		labels[r.ItemID] = append(labels[r.ItemID], r.LabelID)
+	}
+
+	for _, e := range entities {
+		item := e.toTodoItem()
+		item.labelIDs = labels[e.ID]
+		result = append(result, item)
+	}
+	return result, nil
 }
 
-func (repo *repository) selectTodoItems(ctx context.Context, tx *sqlx.Tx) todoItemSelecter {
-	return func(todoListID int) ([]todoItem, error) {
-		type Entity struct {
-			ID          int       `db:"id"`
-			Description string    `db:"description"`
-			Completed   bool      `db:"completed"`
-			CreatedAt   time.Time `db:"created_at"`
+// the sort keys of todoItemPageKeys, in the same order
+var todoItemSortColumns = map[todoItemSort][]string{
+	sortByPosition: {"position", "id"},
+	sortByDueAt: {
+		"COALESCE(due_at, '" + noDueAtSortKey.Format(sortKeyTimeLayout) + "')",
+		"position", "id",
+	},
+	sortByCreatedAt:     {"created_at", "id"},
+	sortByPriority:      {"(" + strconv.Itoa(priorityUrgent) + " - priority)", "position", "id"},
+	sortByCompletedLast: {"completed", "position", "id"},
+}
+
+// the items not completed and due before now. An all-day item, stored at
+// UTC midnight, is overdue from the day after its due date, the day of
+// now in its location. prefix is empty or the alias of todo_item and a dot
+func todoItemOverdueCondition(prefix string, now time.Time) (string, []interface{}) {
+	y, m, d := now.Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	return `(` + prefix + `completed = FALSE AND (
+                (` + prefix + `due_all_day = FALSE AND ` + prefix + `due_at < ?) OR
+                (` + prefix + `due_all_day = TRUE AND ` + prefix + `due_at < ?)))`,
+		[]interface{}{now, today}
+}
+
+func todoItemQueryFilters(q todoItemQuery) (string, []interface{}) {
+	where := "todo_list_id = ?"
+	args := []interface{}{q.todoListID}
+
+	if !q.dueAfter.IsZero() {
+		where += " AND due_at >= ?"
+		args = append(args, q.dueAfter)
+	}
+	if !q.dueBefore.IsZero() {
+		where += " AND due_at < ?"
+		args = append(args, q.dueBefore)
+	}
+	if !q.overdueAt.IsZero() {
+		overdue, overdueArgs := todoItemOverdueCondition("", q.overdueAt)
+		where += " AND " + overdue
+		args = append(args, overdueArgs...)
+	}
+	switch q.status {
+	case statusActive:
+		where += " AND completed = FALSE"
+	case statusCompleted:
+		where += " AND completed = TRUE"
+	}
+	if q.text != "" {
+		where += " AND description LIKE ?"
+		args = append(args, "%"+escapeLike(q.text)+"%")
+	}
+	if !q.createdAfter.IsZero() {
+		where += " AND created_at >= ?"
+		args = append(args, q.createdAfter)
+	}
+	if !q.createdBefore.IsZero() {
+		where += " AND created_at < ?"
+		args = append(args, q.createdBefore)
+	}
+	if len(q.labelIDs) > 0 {
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(q.labelIDs)), ", ")
+		where += ` AND id IN (
+                SELECT todo_item_id FROM todo_item_label
+                WHERE label_id IN (` + placeholders + `)
+                GROUP BY todo_item_id HAVING COUNT(*) = ?)`
+		for _, id := range q.labelIDs {
+			args = append(args, id)
 		}
-		entities := make([]Entity, 0)
+		args = append(args, len(q.labelIDs))
+	}
+	return where, args
+}
+
+func (repo *repository) selectTodoItemsPageNoTx(ctx context.Context) todoItemPageSelecter {
+	return func(q todoItemQuery, after []string, limit int) ([]todoItem, error) {
+		entities := make([]todoItemEntity, 0)
 		result := make([]todoItem, 0)
 
+		columns := todoItemSortColumns[q.sortBy]
+		where, args := todoItemQueryFilters(q)
+		if after != nil {
+			if len(after) != len(columns) {
+				return result, errInvalidPageToken
+			}
+			placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(after)), ", ")
+			where += " AND (" + strings.Join(columns, ", ") + ") > (" + placeholders + ")"
+			for _, key := range after {
+				args = append(args, key)
+			}
+		}
+		args = append(args, limit)
+
 		query := repo.db.Rebind(`
-            SELECT id, description, completed, created_at
-            FROM todo_item WHERE todo_list_id = ?`)
-		err := tx.SelectContext(ctx, &entities, query, todoListID)
+            SELECT ` + todoItemColumns + `
+            FROM todo_item WHERE ` + where + `
+            ORDER BY ` + strings.Join(columns, ", ") + ` LIMIT ?`)
+		err := repo.db.SelectContext(ctx, &entities, query, args...)
 		if err != nil {
 			return result, err
 		}
+		return repo.withTodoItemLabels(ctx, repo.db, entities)
+	}
+}
 
-		for _, e := range entities {
-			result = append(result, todoItem{
-				id:          e.ID,
-				todoListID:  todoListID,
-				description: e.Description,
-				completed:   e.Completed,
-				createdAt:   e.CreatedAt,
-			})
+func (repo *repository) selectTodoItems(ctx context.Context, tx *sqlx.Tx) todoItemSelecter {
+	return func(todoListID int) ([]todoItem, error) {
+		entities := make([]todoItemEntity, 0)
+		result := make([]todoItem, 0)
+
+		query := repo.db.Rebind(`
+            SELECT ` + todoItemColumns + `
+            FROM todo_item WHERE todo_list_id = ?
+            ORDER BY position, id FOR UPDATE`)
+		err := tx.SelectContext(ctx, &entities, query, todoListID)
+		if err != nil {
+			return result, err
 		}
-		return result, nil
+		return repo.withTodoItemLabels(ctx, tx, entities)
 	}
 }
 
 func (repo *repository) updateTodoItemsCompleted(
 	ctx context.Context, tx *sqlx.Tx) todoItemsCompletedUpdater {
-	return func(toBeCompleted, toBeActive []int) error {
+	return func(toBeCompleted, toBeActive []int, accountID int) error {
+		now := time.Now()
 		if len(toBeCompleted) > 0 {
+			// items already completed keep their completion
 			query, args, err := sqlx.In(`
             UPDATE todo_item
-            SET completed = TRUE
-            WHERE id IN (?)`, toBeCompleted)
+            SET completed_at = IF(completed, completed_at, ?),
+                completed_by_account_id = IF(completed, completed_by_account_id, ?),
+                completed = TRUE, version = version + 1, updated_at = ?
+            WHERE id IN (?)`, now, accountID, now, toBeCompleted)
 			if err != nil {
 				return err
 			}
@@ -280,8 +877,10 @@ func (repo *repository) updateTodoItemsCompleted(
 		if len(toBeActive) > 0 {
 			query, args, err := sqlx.In(`
             UPDATE todo_item
-            SET completed = FALSE
-            WHERE id IN (?)`, toBeActive)
+            SET completed = FALSE, completed_at = NULL,
+                completed_by_account_id = NULL,
+                version = version + 1, updated_at = ?
+            WHERE id IN (?)`, now, toBeActive)
 			if err != nil {
 				return err
 			}
@@ -296,14 +895,1018 @@ func (repo *repository) updateTodoItemsCompleted(
 	}
 }
 
-func (repo *repository) deleteTodoItemsCompleted(
-	ctx context.Context, tx *sqlx.Tx,
-) todoItemsCompletedDeleter {
-	return func(todoListID int) error {
+func (repo *repository) updateTodoItem(ctx context.Context, tx *sqlx.Tx) todoItemUpdater {
+	return func(id int, changes todoItemChanges) (time.Time, error) {
+		now := time.Now()
+		sets := []string{"version = version + 1", "updated_at = ?"}
+		args := []interface{}{now}
+		if changes.setDescription {
+			sets = append(sets, "description = ?")
+			args = append(args, changes.description)
+		}
+		if changes.setCompleted {
+			// assignments are evaluated from left to right, completed
+			// is still the previous value in the first two
+			sets = append(sets,
+				`completed_at = CASE WHEN NOT ? THEN NULL
+                    WHEN completed THEN completed_at ELSE ? END`,
+				`completed_by_account_id = CASE WHEN NOT ? THEN NULL
+                    WHEN completed THEN completed_by_account_id ELSE ? END`,
+				"completed = ?")
+			args = append(args,
+				changes.completed, now,
+				changes.completed, changes.completedBy,
+				changes.completed)
+		}
+		if changes.setDueAt {
+			sets = append(sets, "due_at = ?", "due_all_day = ?")
+			args = append(args, nullableTime(changes.dueAt), changes.dueAllDay)
+		}
+		if changes.setRemindAt {
+			// a new reminder time is sent again
+			sets = append(sets, "remind_at = ?", "reminder_sent_at = NULL",
+				"reminder_lease_owner = NULL", "reminder_lease_until = NULL")
+			args = append(args, nullableTime(changes.remindAt))
+		}
+		if changes.setRecurrence {
+			sets = append(sets, "recurrence = ?")
+			args = append(args, changes.recurrence)
+		}
+		if changes.setPriority {
+			sets = append(sets, "priority = ?")
+			args = append(args, changes.priority)
+		}
+		args = append(args, id)
+
 		query := repo.db.Rebind(`
-            DELETE FROM todo_item
-            WHERE todo_list_id = ? AND completed = TRUE`)
-		_, err := tx.ExecContext(ctx, query, todoListID)
+            UPDATE todo_item SET ` + strings.Join(sets, ", ") + `
+            WHERE id = ?`)
+		_, err := tx.ExecContext(ctx, query, args...)
+		return now, err
+	}
+}
+
+// the subtasks of the item are deleted by the foreign key
+func (repo *repository) deleteTodoItem(ctx context.Context, tx *sqlx.Tx) todoItemDeleter {
+	return func(id int) error {
+		query := repo.db.Rebind(`DELETE FROM todo_item WHERE id = ?`)
+		_, err := tx.ExecContext(ctx, query, id)
 		return err
 	}
 }
+
+// the subtasks of the items are deleted by the foreign key
+func (repo *repository) deleteTodoItems(ctx context.Context, tx *sqlx.Tx) todoItemsDeleter {
+	return func(ids []int) error {
+		query, args, err := sqlx.In(`DELETE FROM todo_item WHERE id IN (?)`, ids)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, repo.db.Rebind(query), args...)
+		return err
+	}
+}
+
+// the UPDATE takes the claim atomically, the claimed rows are then
+// found by the owner and the end of the lease
+func (repo *repository) claimDueReminders(ctx context.Context) dueRemindersClaimer {
+	return func(owner string, now time.Time, lease time.Duration, limit int) ([]Reminder, error) {
+		now = now.UTC().Truncate(time.Second)
+		until := now.Add(lease)
+
+		query := repo.db.Rebind(`
+            UPDATE todo_item
+            SET reminder_lease_owner = ?, reminder_lease_until = ?
+            WHERE remind_at <= ? AND reminder_sent_at IS NULL
+                AND completed = FALSE
+                AND (reminder_lease_until IS NULL OR reminder_lease_until < ?)
+            ORDER BY remind_at LIMIT ?`)
+		_, err := repo.db.ExecContext(ctx, query, owner, until, now, now, limit)
+		if err != nil {
+			return nil, err
+		}
+
+		type reminderEntity struct {
+			ItemID      int          `db:"id"`
+			TodoListID  int          `db:"todo_list_id"`
+			AccountID   int          `db:"account_id"`
+			Username    string       `db:"username"`
+			Description string       `db:"description"`
+			DueAt       sql.NullTime `db:"due_at"`
+			DueAllDay   bool         `db:"due_all_day"`
+			RemindAt    time.Time    `db:"remind_at"`
+		}
+		entities := make([]reminderEntity, 0)
+
+		query = repo.db.Rebind(`
+            SELECT i.id, i.todo_list_id, l.account_id, a.username,
+                i.description, i.due_at, i.due_all_day, i.remind_at
+            FROM todo_item i
+            INNER JOIN todo_list l ON l.id = i.todo_list_id
+            INNER JOIN account a ON a.id = l.account_id
+            WHERE i.reminder_lease_owner = ? AND i.reminder_lease_until = ?
+                AND i.reminder_sent_at IS NULL
+            ORDER BY i.remind_at, i.id`)
+		err = repo.db.SelectContext(ctx, &entities, query, owner, until)
+		if err != nil {
+			return nil, err
+		}
+
+		result := make([]Reminder, 0, len(entities))
+		for _, e := range entities {
+			result = append(result, Reminder{
+				ItemID:      e.ItemID,
+				TodoListID:  e.TodoListID,
+				AccountID:   e.AccountID,
+				Username:    e.Username,
+				Description: e.Description,
+				DueAt:       formatDueAt(e.DueAt.Time, e.DueAllDay),
+				RemindAt:    e.RemindAt,
+			})
+		}
+		return result, nil
+	}
+}
+
+func (repo *repository) markReminderSent(ctx context.Context) reminderSentMarker {
+	return func(itemID int, owner string, sentAt time.Time) error {
+		query := repo.db.Rebind(`
+            UPDATE todo_item
+            SET reminder_sent_at = ?,
+                reminder_lease_owner = NULL, reminder_lease_until = NULL
+            WHERE id = ? AND reminder_lease_owner = ?
+                AND reminder_lease_until >= ?`)
+		_, err := repo.db.ExecContext(ctx, query,
+			sentAt.UTC(), itemID, owner, sentAt.UTC())
+		return err
+	}
+}
+
+func (repo *repository) getAccountIDByUsername(ctx context.Context, tx *sqlx.Tx) accountIDGetter {
+	return func(username string) (int, error) {
+		var id int
+		query := repo.db.Rebind(`SELECT id FROM account WHERE username = ?`)
+		err := tx.GetContext(ctx, &id, query, username)
+		return id, err
+	}
+}
+
+func (repo *repository) saveTodoTemplate(ctx context.Context, tx *sqlx.Tx) todoTemplateSaver {
+	return func(accountID int, name string) (int, time.Time, error) {
+		now := time.Now()
+		query := repo.db.Rebind(`
+            INSERT INTO todo_template (account_id, name, created_at)
+            VALUES (?, ?, ?)`)
+
+		res, err := tx.ExecContext(ctx, query, accountID, name, now)
+		if err != nil {
+			return 0, now, err
+		}
+
+		id, err := res.LastInsertId()
+		return int(id), now, err
+	}
+}
+
+func (repo *repository) insertTodoTemplateItems(
+	ctx context.Context, tx *sqlx.Tx,
+) todoTemplateItemsInserter {
+	return func(templateID int, items []todoTemplateItem) error {
+		if len(items) == 0 {
+			return nil
+		}
+
+		values := make([]string, 0, len(items))
+		args := make([]interface{}, 0, 3*len(items))
+		for _, item := range items {
+			values = append(values, "(?, ?, ?)")
+			args = append(args, templateID, item.description, item.position)
+		}
+
+		query := repo.db.Rebind(`
+            INSERT INTO todo_template_item (
+                todo_template_id, description, position)
+            VALUES ` + strings.Join(values, ", "))
+		_, err := tx.ExecContext(ctx, query, args...)
+		return err
+	}
+}
+
+type todoTemplateEntity struct {
+	ID        int       `db:"id"`
+	AccountID int       `db:"account_id"`
+	Name      string    `db:"name"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+type todoTemplateItemEntity struct {
+	TemplateID  int    `db:"todo_template_id"`
+	Description string `db:"description"`
+	Position    string `db:"position"`
+}
+
+// load the items of the templates in one query
+func (repo *repository) withTodoTemplateItems(
+	ctx context.Context, q sqlx.QueryerContext,
+	templates []todoTemplateEntity,
+) ([]todoTemplate, error) {
+	result := make([]todoTemplate, 0, len(templates))
+	if len(templates) == 0 {
+		return result, nil
+	}
+
+	ids := make([]int, 0, len(templates))
+	for _, t := range templates {
+		ids = append(ids, t.ID)
+	}
+
+	query, args, err := sqlx.In(`
+        SELECT todo_template_id, description, position
+        FROM todo_template_item WHERE todo_template_id IN (?)
+        ORDER BY position, id`, ids)
+	if err != nil {
+		return result, err
+	}
+
+	entities := make([]todoTemplateItemEntity, 0)
+	err = sqlx.SelectContext(ctx, q, &entities, repo.db.Rebind(query), args...)
+	if err != nil {
+		return result, err
+	}
+
+	items := make(map[int][]todoTemplateItem)
+	for _, e := range entities {
+		items[e.TemplateID] = append(items[e.TemplateID], todoTemplateItem{
+			description: e.Description,
+			position:    e.Position,
+		})
+	}
+
+	for _, t := range templates {
+		result = append(result, todoTemplate{
+			id:        t.ID,
+			accountID: t.AccountID,
+			name:      t.Name,
+			items:     items[t.ID],
+			createdAt: t.CreatedAt,
+		})
+	}
+	return result, nil
+}
+
+func (repo *repository) getTodoTemplate(ctx context.Context, tx *sqlx.Tx) todoTemplateGetter {
+	return func(id int) (todoTemplate, error) {
+		e := todoTemplateEntity{}
+		query := repo.db.Rebind(`
+            SELECT id, account_id, name, created_at
+            FROM todo_template WHERE id = ?`)
+		err := tx.GetContext(ctx, &e, query, id)
+		if err != nil {
+			return todoTemplate{}, err
+		}
+
+		templates, err := repo.withTodoTemplateItems(ctx, tx, []todoTemplateEntity{e})
+		if err != nil {
+			return todoTemplate{}, err
+		}
+		return templates[0], nil
+	}
+}
+
+func (repo *repository) selectTodoTemplatesNoTx(ctx context.Context) todoTemplatesByAccountSelecter {
+	return func(accountID int) ([]todoTemplate, error) {
+		entities := make([]todoTemplateEntity, 0)
+		query := repo.db.Rebind(`
+            SELECT id, account_id, name, created_at
+            FROM todo_template
+            WHERE account_id = ? OR id IN (
+                SELECT todo_template_id FROM todo_template_share
+                WHERE account_id = ?)
+            ORDER BY name, id`)
+		err := repo.db.SelectContext(ctx, &entities, query, accountID, accountID)
+		if err != nil {
+			return nil, err
+		}
+
+		return repo.withTodoTemplateItems(ctx, repo.db, entities)
+	}
+}
+
+func (repo *repository) isTodoTemplateShared(
+	ctx context.Context, tx *sqlx.Tx,
+) todoTemplateShareChecker {
+	return func(templateID, accountID int) (bool, error) {
+		var count int
+		query := repo.db.Rebind(`
+            SELECT COUNT(*) FROM todo_template_share
+            WHERE todo_template_id = ? AND account_id = ?`)
+		err := tx.GetContext(ctx, &count, query, templateID, accountID)
+		return count > 0, err
+	}
+}
+
+func (repo *repository) shareTodoTemplate(ctx context.Context, tx *sqlx.Tx) todoTemplateSharer {
+	return func(templateID, accountID int) error {
+		query := repo.db.Rebind(`
+            INSERT IGNORE INTO todo_template_share (todo_template_id, account_id)
+            VALUES (?, ?)`)
+		_, err := tx.ExecContext(ctx, query, templateID, accountID)
+		return err
+	}
+}
+
+func (repo *repository) unshareTodoTemplate(ctx context.Context, tx *sqlx.Tx) todoTemplateUnsharer {
+	return func(templateID, accountID int) error {
+		query := repo.db.Rebind(`
+            DELETE FROM todo_template_share
+            WHERE todo_template_id = ? AND account_id = ?`)
+		_, err := tx.ExecContext(ctx, query, templateID, accountID)
+		return err
+	}
+}
+
+// items and shares are deleted by the foreign keys
+func (repo *repository) deleteTodoTemplate(ctx context.Context, tx *sqlx.Tx) todoTemplateDeleter {
+	return func(id int) error {
+		query := repo.db.Rebind(`DELETE FROM todo_template WHERE id = ?`)
+		_, err := tx.ExecContext(ctx, query, id)
+		return err
+	}
+}
+
+func (repo *repository) saveFolder(ctx context.Context, tx *sqlx.Tx) folderSaver {
+	return func(accountID, parentID int, name, position string) (int, time.Time, error) {
+		now := time.Now()
+		query := repo.db.Rebind(`
+            INSERT INTO folder (
+                account_id, parent_id, name, position,
+                created_at, updated_at)
+            VALUES (?, ?, ?, ?, ?, ?)`)
+
+		res, err := tx.ExecContext(ctx, query,
+			accountID, nullableID(parentID), name, position, now, now)
+		if err != nil {
+			return 0, now, err
+		}
+
+		id, err := res.LastInsertId()
+		return int(id), now, err
+	}
+}
+
+type folderEntity struct {
+	ID        int           `db:"id"`
+	AccountID int           `db:"account_id"`
+	ParentID  sql.NullInt64 `db:"parent_id"`
+	Name      string        `db:"name"`
+	Position  string        `db:"position"`
+	CreatedAt time.Time     `db:"created_at"`
+	UpdatedAt time.Time     `db:"updated_at"`
+}
+
+func (e folderEntity) toFolder() folder {
+	return folder{
+		id:        e.ID,
+		accountID: e.AccountID,
+		parentID:  int(e.ParentID.Int64),
+		name:      e.Name,
+		position:  e.Position,
+		createdAt: e.CreatedAt,
+		updatedAt: e.UpdatedAt,
+	}
+}
+
+func (repo *repository) getFolder(ctx context.Context, tx *sqlx.Tx) folderGetter {
+	return func(id int) (folder, error) {
+		e := folderEntity{}
+		query := repo.db.Rebind(`
+            SELECT id, account_id, parent_id, name, position,
+                created_at, updated_at
+            FROM folder WHERE id = ?`)
+		err := tx.GetContext(ctx, &e, query, id)
+		return e.toFolder(), err
+	}
+}
+
+func (repo *repository) selectFoldersNoTx(ctx context.Context) foldersByAccountSelecter {
+	return func(accountID int) ([]folder, error) {
+		entities := make([]folderEntity, 0)
+		result := make([]folder, 0)
+
+		query := repo.db.Rebind(`
+            SELECT id, account_id, parent_id, name, position,
+                created_at, updated_at
+            FROM folder WHERE account_id = ?
+            ORDER BY position, id`)
+		err := repo.db.SelectContext(ctx, &entities, query, accountID)
+		if err != nil {
+			return result, err
+		}
+
+		for _, e := range entities {
+			result = append(result, e.toFolder())
+		}
+		return result, nil
+	}
+}
+
+// locks the end of the account's folders so that concurrent appends are serialized
+func (repo *repository) getLastFolderPosition(
+	ctx context.Context, tx *sqlx.Tx,
+) folderLastPositionGetter {
+	return func(accountID int) (string, error) {
+		var position string
+		query := repo.db.Rebind(`
+            SELECT COALESCE(MAX(position), '') FROM folder
+            WHERE account_id = ? FOR UPDATE`)
+		err := tx.GetContext(ctx, &position, query, accountID)
+		return position, err
+	}
+}
+
+func (repo *repository) renameFolder(ctx context.Context, tx *sqlx.Tx) folderRenamer {
+	return func(id int, name string) (time.Time, error) {
+		now := time.Now()
+		query := repo.db.Rebind(`
+            UPDATE folder SET name = ?, updated_at = ? WHERE id = ?`)
+		_, err := tx.ExecContext(ctx, query, name, now, id)
+		return now, err
+	}
+}
+
+// todo_list.folder_id is set to NULL by the foreign key
+func (repo *repository) deleteFolder(ctx context.Context, tx *sqlx.Tx) folderDeleter {
+	return func(id int) error {
+		query := repo.db.Rebind(`
+            UPDATE folder SET parent_id = NULL WHERE parent_id = ?`)
+		_, err := tx.ExecContext(ctx, query, id)
+		if err != nil {
+			return err
+		}
+
+		query = repo.db.Rebind(`DELETE FROM folder WHERE id = ?`)
+		_, err = tx.ExecContext(ctx, query, id)
+		return err
+	}
+}
+
+func (repo *repository) updateTodoListFolder(
+	ctx context.Context, tx *sqlx.Tx,
+) todoListFolderUpdater {
+	return func(todoListID, folderID int) (time.Time, error) {
+		now := time.Now()
+		query := repo.db.Rebind(`
+            UPDATE todo_list SET folder_id = ?, updated_at = ?,
+                version = version + 1
+            WHERE id = ?`)
+		_, err := tx.ExecContext(ctx, query, nullableID(folderID), now, todoListID)
+		return now, err
+	}
+}
+
+type labelEntity struct {
+	ID        int       `db:"id"`
+	AccountID int       `db:"account_id"`
+	Name      string    `db:"name"`
+	Color     string    `db:"color"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+func (e labelEntity) toLabel() label {
+	return label{
+		id:        e.ID,
+		accountID: e.AccountID,
+		name:      e.Name,
+		color:     e.Color,
+		createdAt: e.CreatedAt,
+	}
+}
+
+func (repo *repository) saveLabel(ctx context.Context, tx *sqlx.Tx) labelSaver {
+	return func(accountID int, name, color string) (int, time.Time, error) {
+		now := time.Now()
+		query := repo.db.Rebind(`
+            INSERT INTO label(account_id, name, color, created_at)
+            VALUES (?, ?, ?, ?)`)
+		res, err := tx.ExecContext(ctx, query, accountID, name, color, now)
+		if err != nil {
+			return 0, now, err
+		}
+
+		id, err := res.LastInsertId()
+		return int(id), now, err
+	}
+}
+
+func (repo *repository) getLabel(ctx context.Context, q sqlx.QueryerContext) labelGetter {
+	return func(id int) (label, error) {
+		e := labelEntity{}
+		query := repo.db.Rebind(`
+            SELECT id, account_id, name, color, created_at
+            FROM label WHERE id = ?`)
+		err := sqlx.GetContext(ctx, q, &e, query, id)
+		return e.toLabel(), err
+	}
+}
+
+func (repo *repository) selectLabels(ctx context.Context, q sqlx.QueryerContext) labelsByAccountSelecter {
+	return func(accountID int) ([]label, error) {
+		entities := make([]labelEntity, 0)
+		result := make([]label, 0)
+
+		query := repo.db.Rebind(`
+            SELECT id, account_id, name, color, created_at
+            FROM label WHERE account_id = ?
+            ORDER BY name, id`)
+		err := sqlx.SelectContext(ctx, q, &entities, query, accountID)
+		if err != nil {
+			return result, err
+		}
+
+		for _, e := range entities {
+			result = append(result, e.toLabel())
+		}
+		return result, nil
+	}
+}
+
+func (repo *repository) updateLabel(ctx context.Context, tx *sqlx.Tx) labelUpdater {
+	return func(id int, name, color string) error {
+		query := repo.db.Rebind(`
+            UPDATE label SET name = ?, color = ? WHERE id = ?`)
+		_, err := tx.ExecContext(ctx, query, name, color, id)
+		return err
+	}
+}
+
+// todo_item_label rows are deleted by the foreign key
+func (repo *repository) deleteLabel(ctx context.Context, tx *sqlx.Tx) labelDeleter {
+	return func(id int) error {
+		query := repo.db.Rebind(`DELETE FROM label WHERE id = ?`)
+		_, err := tx.ExecContext(ctx, query, id)
+		return err
+	}
+}
+
+func (repo *repository) attachTodoItemLabels(
+	ctx context.Context, tx *sqlx.Tx,
+) todoItemLabelsAttacher {
+	return func(itemID int, labelIDs []int) error {
+		values := make([]string, 0, len(labelIDs))
+		args := make([]interface{}, 0, 2*len(labelIDs))
+		for _, labelID := range labelIDs {
+			values = append(values, "(?, ?)")
+			args = append(args, itemID, labelID)
+		}
+
+		query := repo.db.Rebind(`
+            INSERT IGNORE INTO todo_item_label(todo_item_id, label_id)
+            VALUES ` + strings.Join(values, ", "))
+		_, err := tx.ExecContext(ctx, query, args...)
+		return err
+	}
+}
+
+func (repo *repository) detachTodoItemLabel(
+	ctx context.Context, tx *sqlx.Tx,
+) todoItemLabelDetacher {
+	return func(itemID, labelID int) error {
+		query := repo.db.Rebind(`
+            DELETE FROM todo_item_label
+            WHERE todo_item_id = ? AND label_id = ?`)
+		_, err := tx.ExecContext(ctx, query, itemID, labelID)
+		return err
+	}
+}
+
+// keys are the todo list id, the position and the id of the last item
+func (repo *repository) selectLabelItemsPageNoTx(ctx context.Context) labelItemsPageSelecter {
+	return func(labelID int, after []string, limit int) ([]todoItem, error) {
+		entities := make([]todoItemEntity, 0)
+
+		where := `id IN (
+            SELECT todo_item_id FROM todo_item_label WHERE label_id = ?)`
+		args := []interface{}{labelID}
+		if after != nil {
+			if len(after) != 3 {
+				return nil, errInvalidPageToken
+			}
+			where += " AND (todo_list_id, position, id) > (?, ?, ?)"
+			args = append(args, after[0], after[1], after[2])
+		}
+		args = append(args, limit)
+
+		query := repo.db.Rebind(`
+            SELECT ` + todoItemColumns + `
+            FROM todo_item WHERE ` + where + `
+            ORDER BY todo_list_id, position, id LIMIT ?`)
+		err := repo.db.SelectContext(ctx, &entities, query, args...)
+		if err != nil {
+			return nil, err
+		}
+		return repo.withTodoItemLabels(ctx, repo.db, entities)
+	}
+}
+
+// the fulltext indexes of list names, item descriptions and notes
+// are searched in natural language mode with the words of a plain query.
+// Other queries are compiled to a condition on the items, ranked with
+// the words and phrases which are not negated
+func (repo *repository) selectSearchHitsNoTx(ctx context.Context) searchHitsSelecter {
+	return func(accountID int, node searchNode, offset, limit int) ([]searchHit, error) {
+		type rankedHit struct {
+			Kind string `db:"kind"`
+			ID   int    `db:"id"`
+		}
+		ranked := make([]rankedHit, 0)
+
+		var query string
+		var args []interface{}
+		if words, ok := searchPlainWords(node); ok {
+			text := strings.Join(words, " ")
+			query = `
+            SELECT kind, id FROM (
+                SELECT 'list' AS kind, id,
+                    MATCH (name) AGAINST (?) AS score, updated_at
+                FROM todo_list
+                WHERE account_id = ? AND MATCH (name) AGAINST (?)
+                UNION ALL
+                SELECT 'item' AS kind, i.id,
+                    MATCH (i.description) AGAINST (?) +
+                        COALESCE(MATCH (n.body) AGAINST (?), 0) AS score,
+                    i.updated_at
+                FROM todo_item i
+                JOIN todo_list l ON l.id = i.todo_list_id
+                LEFT JOIN todo_item_note n ON n.todo_item_id = i.id
+                WHERE l.account_id = ? AND (MATCH (i.description) AGAINST (?)
+                    OR MATCH (n.body) AGAINST (?))
+            ) hits
+            ORDER BY score DESC, updated_at DESC, kind, id
+            LIMIT ? OFFSET ?`
+			args = []interface{}{
+				text, accountID, text,
+				text, text, accountID, text, text,
+				limit, offset,
+			}
+		} else {
+			where, whereArgs := searchCondition(node)
+			text := strings.Join(searchPositiveTexts(node), " ")
+			query = `
+            SELECT 'item' AS kind, i.id
+            FROM todo_item i
+            JOIN todo_list l ON l.id = i.todo_list_id
+            LEFT JOIN todo_item_note n ON n.todo_item_id = i.id
+            WHERE l.account_id = ? AND ` + where + `
+            ORDER BY MATCH (i.description) AGAINST (?) +
+                COALESCE(MATCH (n.body) AGAINST (?), 0) DESC,
+                i.updated_at DESC, i.id
+            LIMIT ? OFFSET ?`
+			args = append([]interface{}{accountID}, whereArgs...)
+			args = append(args, text, text, limit, offset)
+		}
+
+		err := repo.db.SelectContext(ctx, &ranked, repo.db.Rebind(query), args...)
+		if err != nil || len(ranked) == 0 {
+			return nil, err
+		}
+
+		listIDs := make([]int, 0)
+		itemIDs := make([]int, 0)
+		for _, r := range ranked {
+			if r.Kind == "list" {
+				listIDs = append(listIDs, r.ID)
+			} else {
+				itemIDs = append(itemIDs, r.ID)
+			}
+		}
+
+		lists, err := repo.selectTodoListsByIDsNoTx(ctx, listIDs)
+		if err != nil {
+			return nil, err
+		}
+		items, err := repo.selectTodoItemsByIDsNoTx(ctx, itemIDs)
+		if err != nil {
+			return nil, err
+		}
+		notes, err := repo.selectTodoItemsNotesNoTx(ctx, itemIDs)
+		if err != nil {
+			return nil, err
+		}
+
+		// rows deleted since the ranking are left out
+		result := make([]searchHit, 0, len(ranked))
+		for _, r := range ranked {
+			if r.Kind == "list" {
+				if l, ok := lists[r.ID]; ok {
+					result = append(result, searchHit{list: l})
+				}
+			} else if item, ok := items[r.ID]; ok {
+				result = append(result, searchHit{item: item, notes: notes[r.ID]})
+			}
+		}
+		return result, nil
+	}
+}
+
+// columns of the day operators of a search query
+var searchDayColumns = map[string]string{
+	"due":       "i.due_at",
+	"created":   "i.created_at",
+	"completed": "i.completed_at",
+}
+
+// the condition on the columns of todo_item i, todo_list l and
+// todo_item_note n matching the node, with its arguments
+func searchCondition(node searchNode) (string, []interface{}) {
+	switch n := node.(type) {
+	case searchAnd:
+		return searchConditions(n.nodes, " AND ")
+	case searchOr:
+		return searchConditions(n.nodes, " OR ")
+	case searchNot:
+		// a condition on a NULL column is NULL, its negation too
+		where, args := searchCondition(n.node)
+		return "NOT COALESCE(" + where + ", FALSE)", args
+	case searchText:
+		pattern := "%" + escapeLike(n.text) + "%"
+		return "(i.description LIKE ? OR COALESCE(n.body, '') LIKE ?)",
+			[]interface{}{pattern, pattern}
+	case searchFilter:
+		return searchFilterCondition(n)
+	}
+	panic("unknown search node")
+}
+
+func searchConditions(nodes []searchNode, operator string) (string, []interface{}) {
+	conditions := make([]string, 0, len(nodes))
+	args := make([]interface{}, 0)
+	for _, node := range nodes {
+		where, nodeArgs := searchCondition(node)
+		conditions = append(conditions, where)
+		args = append(args, nodeArgs...)
+	}
+	return "(" + strings.Join(conditions, operator) + ")", args
+}
+
+func searchFilterCondition(f searchFilter) (string, []interface{}) {
+	switch f.field {
+	case "is":
+		switch f.value {
+		case "active":
+			return "(i.completed = FALSE)", nil
+		case "completed":
+			return "(i.completed = TRUE)", nil
+		}
+		return todoItemOverdueCondition("i.", f.day)
+
+	case "list":
+		return "(l.name = ?)", []interface{}{f.value}
+
+	case "label":
+		return `EXISTS (
+            SELECT 1 FROM todo_item_label il
+            JOIN label lb ON lb.id = il.label_id
+            WHERE il.todo_item_id = i.id AND lb.name = ?)`, []interface{}{f.value}
+
+	case "priority":
+		return "(i.priority " + f.op + " ?)", []interface{}{f.priority}
+	}
+
+	column := searchDayColumns[f.field]
+	if f.day.IsZero() {
+		return "(" + column + " IS NULL)", nil
+	}
+	if f.field != "due" {
+		return searchDayCondition(column, f.op, f.day)
+	}
+
+	// all-day due dates are the day at UTC midnight
+	y, m, d := f.day.Date()
+	timed, timedArgs := searchDayCondition(column, f.op, f.day)
+	allDay, allDayArgs := searchDayCondition(
+		column, f.op, time.Date(y, m, d, 0, 0, 0, 0, time.UTC))
+	return "((i.due_all_day = FALSE AND " + timed + ") OR " +
+			"(i.due_all_day = TRUE AND " + allDay + "))",
+		append(timedArgs, allDayArgs...)
+}
+
+// the condition comparing column to the day starting at day
+func searchDayCondition(column, op string, day time.Time) (string, []interface{}) {
+	nextDay := day.AddDate(0, 0, 1)
+	switch op {
+	case "<":
+		return "(" + column + " < ?)", []interface{}{day}
+	case "<=":
+		return "(" + column + " < ?)", []interface{}{nextDay}
+	case ">":
+		return "(" + column + " >= ?)", []interface{}{nextDay}
+	case ">=":
+		return "(" + column + " >= ?)", []interface{}{day}
+	}
+	return "(" + column + " >= ? AND " + column + " < ?)", []interface{}{day, nextDay}
+}
+
+func (repo *repository) selectTodoListsByIDsNoTx(
+	ctx context.Context, ids []int,
+) (map[int]todoList, error) {
+	result := make(map[int]todoList)
+	if len(ids) == 0 {
+		return result, nil
+	}
+
+	query, args, err := sqlx.In(`
+        SELECT id, account_id, name, position, folder_id,
+            version, created_at, updated_at
+        FROM todo_list WHERE id IN (?)`, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	entities := make([]todoListEntity, 0)
+	err = repo.db.SelectContext(ctx, &entities, repo.db.Rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entities {
+		result[e.ID] = e.toTodoList()
+	}
+	return result, nil
+}
+
+func (repo *repository) selectTodoItemsByIDsNoTx(
+	ctx context.Context, ids []int,
+) (map[int]todoItem, error) {
+	result := make(map[int]todoItem)
+	if len(ids) == 0 {
+		return result, nil
+	}
+
+	query, args, err := sqlx.In(`
+        SELECT `+todoItemColumns+`
+        FROM todo_item WHERE id IN (?)`, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	entities := make([]todoItemEntity, 0)
+	err = repo.db.SelectContext(ctx, &entities, repo.db.Rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := repo.withTodoItemLabels(ctx, repo.db, entities)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		result[item.id] = item
+	}
+	return result, nil
+}
+
+func (repo *repository) selectTodoItemsNotesNoTx(
+	ctx context.Context, ids []int,
+) (map[int]string, error) {
+	result := make(map[int]string)
+	if len(ids) == 0 {
+		return result, nil
+	}
+
+	query, args, err := sqlx.In(`
+        SELECT todo_item_id, body
+        FROM todo_item_note WHERE todo_item_id IN (?)`, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	type itemNote struct {
+		ItemID int    `db:"todo_item_id"`
+		Body   string `db:"body"`
+	}
+	rows := make([]itemNote, 0)
+	err = repo.db.SelectContext(ctx, &rows, repo.db.Rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range rows {
+		result[r.ItemID] = r.Body
+	}
+	return result, nil
+}
+
+type smartListEntity struct {
+	ID        int       `db:"id"`
+	AccountID int       `db:"account_id"`
+	Name      string    `db:"name"`
+	Query     string    `db:"query"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+func (e smartListEntity) toSmartList() smartList {
+	return smartList{
+		id:        e.ID,
+		accountID: e.AccountID,
+		name:      e.Name,
+		query:     e.Query,
+		createdAt: e.CreatedAt,
+	}
+}
+
+func (repo *repository) saveSmartList(ctx context.Context, tx *sqlx.Tx) smartListSaver {
+	return func(accountID int, name, searchQuery string) (int, time.Time, error) {
+		now := time.Now()
+		query := repo.db.Rebind(`
+            INSERT INTO smart_list(account_id, name, query, created_at)
+            VALUES (?, ?, ?, ?)`)
+		res, err := tx.ExecContext(ctx, query, accountID, name, searchQuery, now)
+		if err != nil {
+			return 0, now, err
+		}
+
+		id, err := res.LastInsertId()
+		return int(id), now, err
+	}
+}
+
+func (repo *repository) getSmartList(ctx context.Context, q sqlx.QueryerContext) smartListGetter {
+	return func(id int) (smartList, error) {
+		e := smartListEntity{}
+		query := repo.db.Rebind(`
+            SELECT id, account_id, name, query, created_at
+            FROM smart_list WHERE id = ?`)
+		err := sqlx.GetContext(ctx, q, &e, query, id)
+		return e.toSmartList(), err
+	}
+}
+
+func (repo *repository) selectSmartListsNoTx(ctx context.Context) smartListsByAccountSelecter {
+	return func(accountID int) ([]smartList, error) {
+		entities := make([]smartListEntity, 0)
+		result := make([]smartList, 0)
+
+		query := repo.db.Rebind(`
+            SELECT id, account_id, name, query, created_at
+            FROM smart_list WHERE account_id = ?
+            ORDER BY name, id`)
+		err := repo.db.SelectContext(ctx, &entities, query, accountID)
+		if err != nil {
+			return result, err
+		}
+
+		for _, e := range entities {
+			result = append(result, e.toSmartList())
+		}
+		return result, nil
+	}
+}
+
+func (repo *repository) updateSmartList(ctx context.Context, tx *sqlx.Tx) smartListUpdater {
+	return func(id int, name, searchQuery string) error {
+		query := repo.db.Rebind(`
+            UPDATE smart_list SET name = ?, query = ? WHERE id = ?`)
+		_, err := tx.ExecContext(ctx, query, name, searchQuery, id)
+		return err
+	}
+}
+
+func (repo *repository) deleteSmartList(ctx context.Context, tx *sqlx.Tx) smartListDeleter {
+	return func(id int) error {
+		query := repo.db.Rebind(`DELETE FROM smart_list WHERE id = ?`)
+		_, err := tx.ExecContext(ctx, query, id)
+		return err
+	}
+}
+
+// keys are the due date sort key and the id of the last item
+func (repo *repository) selectSmartListItemsPageNoTx(ctx context.Context) smartListItemsPageSelecter {
+	return func(accountID int, node searchNode, after []string, limit int) ([]todoItem, error) {
+		entities := make([]todoItemEntity, 0)
+
+		dueKey := "COALESCE(i.due_at, '" + noDueAtSortKey.Format(sortKeyTimeLayout) + "')"
+		condition, args := searchCondition(node)
+		where := "l.account_id = ? AND " + condition
+		args = append([]interface{}{accountID}, args...)
+		if after != nil {
+			if len(after) != 2 {
+				return nil, errInvalidPageToken
+			}
+			where += " AND (" + dueKey + ", i.id) > (?, ?)"
+			args = append(args, after[0], after[1])
+		}
+		args = append(args, limit)
+
+		query := repo.db.Rebind(`
+            SELECT ` + todoItemColumnsOf("i") + `
+            FROM todo_item i
+            JOIN todo_list l ON l.id = i.todo_list_id
+            LEFT JOIN todo_item_note n ON n.todo_item_id = i.id
+            WHERE ` + where + `
+            ORDER BY ` + dueKey + `, i.id LIMIT ?`)
+		err := repo.db.SelectContext(ctx, &entities, query, args...)
+		if err != nil {
+			return nil, err
+		}
+		return repo.withTodoItemLabels(ctx, repo.db, entities)
+	}
+}
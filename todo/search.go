@@ -0,0 +1,208 @@
+package todo
+
+import (
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Search finds the todo lists and items of an account whose name,
+// description or notes contain the words of a query, using the fulltext
+// indexes of the database. Queries with operators, see searchNode,
+// only find items
+const maxSearchQueryLength = 100
+
+// runes of the notes returned around the first matching word
+const (
+	searchSnippetLength = 120
+	searchSnippetBefore = 40
+)
+
+// a matching list or item, the other one is zero.
+// notes are the notes of the item
+type searchHit struct {
+	list  todoList
+	item  todoItem
+	notes string
+}
+
+// ranked by relevance, then the most recently updated first
+type searchHitsSelecter = func(accountID int, query searchNode, offset, limit int) ([]searchHit, error)
+
+type searchHighlight struct {
+	// "name", "description" or "notes"
+	field string
+	// escaped HTML with the matching words in <mark>
+	snippet string
+}
+
+type searchResult struct {
+	searchHit
+	highlights []searchHighlight
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// start and end indexes of the words of the text
+func wordSpans(text []rune) [][2]int {
+	spans := make([][2]int, 0)
+	start := -1
+	for i, r := range text {
+		if isWordRune(r) {
+			if start == -1 {
+				start = i
+			}
+			continue
+		}
+		if start != -1 {
+			spans = append(spans, [2]int{start, i})
+			start = -1
+		}
+	}
+	if start != -1 {
+		spans = append(spans, [2]int{start, len(text)})
+	}
+	return spans
+}
+
+// the lower case words of the query
+func searchWords(query string) map[string]bool {
+	text := []rune(query)
+	words := make(map[string]bool)
+	for _, span := range wordSpans(text) {
+		words[strings.ToLower(string(text[span[0]:span[1]]))] = true
+	}
+	return words
+}
+
+// escape the text with the words in <mark>,
+// false if none of the words is found
+func highlightWords(text []rune, words map[string]bool) (string, bool) {
+	var b strings.Builder
+	found := false
+	last := 0
+	for _, span := range wordSpans(text) {
+		word := string(text[span[0]:span[1]])
+		if !words[strings.ToLower(word)] {
+			continue
+		}
+
+		found = true
+		b.WriteString(html.EscapeString(string(text[last:span[0]])))
+		b.WriteString("<mark>" + html.EscapeString(word) + "</mark>")
+		last = span[1]
+	}
+	b.WriteString(html.EscapeString(string(text[last:])))
+	return b.String(), found
+}
+
+// the part of the notes around the first matching word
+func notesSnippet(notes string, words map[string]bool) (string, bool) {
+	text := []rune(notes)
+	for _, span := range wordSpans(text) {
+		if !words[strings.ToLower(string(text[span[0]:span[1]]))] {
+			continue
+		}
+
+		start := span[0] - searchSnippetBefore
+		if start < 0 {
+			start = 0
+		}
+		end := start + searchSnippetLength
+		if end > len(text) {
+			end = len(text)
+		}
+
+		snippet, _ := highlightWords(text[start:end], words)
+		if start > 0 {
+			snippet = "…" + snippet
+		}
+		if end < len(text) {
+			snippet += "…"
+		}
+		return snippet, true
+	}
+	return "", false
+}
+
+func searchHighlights(hit searchHit, words map[string]bool) []searchHighlight {
+	highlights := make([]searchHighlight, 0)
+	if hit.list.id != 0 {
+		if snippet, ok := highlightWords([]rune(hit.list.name), words); ok {
+			highlights = append(highlights, searchHighlight{field: "name", snippet: snippet})
+		}
+		return highlights
+	}
+
+	if snippet, ok := highlightWords([]rune(hit.item.description), words); ok {
+		highlights = append(highlights, searchHighlight{field: "description", snippet: snippet})
+	}
+	if snippet, ok := notesSnippet(hit.notes, words); ok {
+		highlights = append(highlights, searchHighlight{field: "notes", snippet: snippet})
+	}
+	return highlights
+}
+
+// return the matching lists and items of the account and the token of the
+// next page. Results are ranked, so the token holds the offset of the page
+func search(
+	accountID int, query string, now time.Time,
+	pageSize int, pageToken string,
+	codec pageTokenCodec,
+	selecter searchHitsSelecter,
+) ([]searchResult, string, error) {
+	query = strings.TrimSpace(query)
+	if query == "" || len(query) > maxSearchQueryLength {
+		return nil, "", errInvalidInput
+	}
+
+	node, err := parseSearchQuery(query, now)
+	if err != nil {
+		return nil, "", err
+	}
+	words := searchWords(strings.Join(searchPositiveTexts(node), " "))
+
+	size, err := normalizePageSize(pageSize)
+	if err != nil {
+		return nil, "", err
+	}
+
+	scope := fmt.Sprintf("search:%d:%s", accountID, query)
+	after, err := codec.decode(scope, pageToken)
+	if err != nil {
+		return nil, "", err
+	}
+
+	offset := 0
+	if after != nil {
+		offset, err = strconv.Atoi(after[0])
+		if err != nil || len(after) != 1 || offset < 0 {
+			return nil, "", errInvalidPageToken
+		}
+	}
+
+	hits, err := selecter(accountID, node, offset, size+1)
+	if err != nil {
+		return nil, "", err
+	}
+
+	next := ""
+	if len(hits) > size {
+		hits = hits[:size]
+		next = codec.encode(scope, []string{strconv.Itoa(offset + size)})
+	}
+
+	results := make([]searchResult, 0, len(hits))
+	for _, hit := range hits {
+		results = append(results, searchResult{
+			searchHit:  hit,
+			highlights: searchHighlights(hit, words),
+		})
+	}
+	return results, next, nil
+}
@@ -0,0 +1,76 @@
+package todo
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestHighlightWords(t *testing.T) {
+	words := searchWords("Milk, <eggs>")
+	snippet, ok := highlightWords([]rune("Buy milk & EGGS <b>now</b>"), words)
+	expected := "Buy <mark>milk</mark> &amp; <mark>EGGS</mark> &lt;b&gt;now&lt;/b&gt;"
+	if !ok || snippet != expected {
+		t.Errorf("should mark the words and escape the rest, actual: %s", snippet)
+	}
+
+	_, ok = highlightWords([]rune("buttermilk"), words)
+	if ok {
+		t.Error("should match whole words only")
+	}
+}
+
+func TestNotesSnippet(t *testing.T) {
+	notes := ""
+	for i := 0; i < 50; i++ {
+		notes += "word" + strconv.Itoa(i) + " "
+	}
+	snippet, ok := notesSnippet(notes, searchWords("word25"))
+	if !ok || snippet[:len("…")] != "…" || snippet[len(snippet)-len("…"):] != "…" {
+		t.Errorf("should cut the notes around the match, actual: %s", snippet)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	codec := newPageTokenCodec([]byte("secret"))
+	now := time.Now()
+	hits := []searchHit{
+		{list: todoList{id: 1, name: "Groceries"}},
+		{item: todoItem{id: 2, description: "Buy groceries"}, notes: "at the market"},
+		{item: todoItem{id: 3, description: "Call mum"}, notes: "about the groceries"},
+	}
+	selecter := func(accountID int, query searchNode, offset, limit int) ([]searchHit, error) {
+		end := offset + limit
+		if end > len(hits) {
+			end = len(hits)
+		}
+		return hits[offset:end], nil
+	}
+
+	_, _, err := search(10, "   ", now, 0, "", codec, selecter)
+	if err != errInvalidInput {
+		t.Errorf("empty query should be invalid, actual: %v", err)
+	}
+
+	results, next, err := search(10, "groceries", now, 2, "", codec, selecter)
+	if err != nil || len(results) != 2 || next == "" {
+		t.Fatalf("should return the first page, actual: %v %q %v", results, next, err)
+	}
+	if len(results[0].highlights) != 1 || results[0].highlights[0].field != "name" ||
+		len(results[1].highlights) != 1 || results[1].highlights[0].field != "description" {
+		t.Errorf("should highlight the matching fields, actual: %+v", results)
+	}
+
+	results, next, err = search(10, "groceries", now, 2, next, codec, selecter)
+	if err != nil || len(results) != 1 || results[0].item.id != 3 || next != "" {
+		t.Fatalf("should return the last page, actual: %v %q %v", results, next, err)
+	}
+	if results[0].highlights[0].field != "notes" {
+		t.Errorf("should highlight the notes, actual: %+v", results[0].highlights)
+	}
+
+	_, _, err = search(10, "market", now, 2, codec.encode("search:10:groceries", []string{"2"}), codec, selecter)
+	if err != errInvalidPageToken {
+		t.Errorf("token of another query should be invalid, actual: %v", err)
+	}
+}
@@ -0,0 +1,464 @@
+package todo
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A search query is a list of terms which must all match, like
+//
+//	is:open list:"Groceries" due:<7d label:work created:>2026-01-01 "exact phrase"
+//
+// A term is a word, a "quoted phrase" or an operator key:value, with the
+// value quoted if it has spaces. A term can be negated with a leading -,
+// terms can be grouped with parentheses and combined with OR.
+// Operators:
+//
+//	is:open, is:active, is:completed, is:done, is:overdue
+//	list:name and label:name
+//	due:day, created:day, completed:day, prefixed with <, <=, >, >= or =,
+//	    with a day either YYYY-MM-DD, today, tomorrow, yesterday, week
+//	    (the Monday of this week) or a number of days or weeks from today
+//	    like 7d, -2w. due:none for no due date
+//	priority:level, with the same prefixes, the level being none, low,
+//	    medium, high, urgent or 0 to 4
+type searchNode interface {
+	// rune index of the node in the query
+	position() int
+}
+
+type searchAnd struct {
+	pos   int
+	nodes []searchNode
+}
+
+type searchOr struct {
+	pos   int
+	nodes []searchNode
+}
+
+type searchNot struct {
+	pos  int
+	node searchNode
+}
+
+// a word, or a phrase matching as is
+type searchText struct {
+	pos    int
+	text   string
+	phrase bool
+}
+
+type searchFilter struct {
+	pos   int
+	field string
+	// "=", "<", "<=", ">" or ">="
+	op string
+	// status of is, name of list and label
+	value string
+	// due, created and completed: the start of the day in the location of
+	// the query, zero for due:none. is:overdue: the time of the query
+	day      time.Time
+	priority int
+}
+
+func (n searchAnd) position() int    { return n.pos }
+func (n searchOr) position() int     { return n.pos }
+func (n searchNot) position() int    { return n.pos }
+func (n searchText) position() int   { return n.pos }
+func (n searchFilter) position() int { return n.pos }
+
+// searchQueryError tells where a query can't be parsed
+type searchQueryError struct {
+	pos     int
+	message string
+}
+
+func (e *searchQueryError) Error() string {
+	return fmt.Sprintf("invalid query at column %d: %s", e.pos+1, e.message)
+}
+
+func searchQueryErrorf(pos int, format string, args ...interface{}) error {
+	return &searchQueryError{pos: pos, message: fmt.Sprintf(format, args...)}
+}
+
+type searchTokenKind int
+
+const (
+	tokenEnd searchTokenKind = iota
+	tokenWord
+	tokenPhrase
+	tokenFilter
+	tokenNot
+	tokenOr
+	tokenOpen
+	tokenClose
+)
+
+type searchToken struct {
+	kind searchTokenKind
+	pos  int
+	text string
+	// the key of a filter, text is its value
+	key string
+	// rune index of the value of a filter
+	valuePos int
+}
+
+var searchFilterKeyRegexp = regexp.MustCompile(`^[a-zA-Z]+$`)
+
+func isSearchSpace(r rune) bool {
+	return r == ' ' || r == '\t' || r == '\n' || r == '\r'
+}
+
+func isSearchDelimiter(r rune) bool {
+	return isSearchSpace(r) || r == '(' || r == ')' || r == '"'
+}
+
+// the quoted text starting at i, and the index after the closing quote
+func readSearchPhrase(query []rune, i int) (string, int, error) {
+	end := i + 1
+	for end < len(query) && query[end] != '"' {
+		end++
+	}
+	if end == len(query) {
+		return "", 0, searchQueryErrorf(i, "missing closing quote")
+	}
+
+	text := strings.TrimSpace(string(query[i+1 : end]))
+	if text == "" {
+		return "", 0, searchQueryErrorf(i, "empty quotes")
+	}
+	return text, end + 1, nil
+}
+
+func tokenizeSearchQuery(query []rune) ([]searchToken, error) {
+	tokens := make([]searchToken, 0)
+	i := 0
+	for i < len(query) {
+		r := query[i]
+		switch {
+		case isSearchSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, searchToken{kind: tokenOpen, pos: i})
+			i++
+		case r == ')':
+			tokens = append(tokens, searchToken{kind: tokenClose, pos: i})
+			i++
+		case r == '"':
+			text, next, err := readSearchPhrase(query, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, searchToken{kind: tokenPhrase, pos: i, text: text})
+			i = next
+		case r == '-' && i+1 < len(query) && !isSearchSpace(query[i+1]) && query[i+1] != ')':
+			tokens = append(tokens, searchToken{kind: tokenNot, pos: i})
+			i++
+		default:
+			start := i
+			for i < len(query) && !isSearchDelimiter(query[i]) {
+				i++
+			}
+			text := string(query[start:i])
+
+			colon := strings.Index(text, ":")
+			if colon > 0 && searchFilterKeyRegexp.MatchString(text[:colon]) {
+				token := searchToken{
+					kind:     tokenFilter,
+					pos:      start,
+					key:      strings.ToLower(text[:colon]),
+					text:     text[colon+1:],
+					valuePos: start + len([]rune(text[:colon+1])),
+				}
+				// a quoted value
+				if token.text == "" && i < len(query) && query[i] == '"' {
+					value, next, err := readSearchPhrase(query, i)
+					if err != nil {
+						return nil, err
+					}
+					token.text = value
+					i = next
+				}
+				tokens = append(tokens, token)
+				continue
+			}
+
+			if text == "OR" {
+				tokens = append(tokens, searchToken{kind: tokenOr, pos: start})
+				continue
+			}
+			tokens = append(tokens, searchToken{kind: tokenWord, pos: start, text: text})
+		}
+	}
+	return append(tokens, searchToken{kind: tokenEnd, pos: len(query)}), nil
+}
+
+type searchParser struct {
+	tokens []searchToken
+	next   int
+	now    time.Time
+}
+
+func (p *searchParser) peek() searchToken {
+	return p.tokens[p.next]
+}
+
+func (p *searchParser) consume() searchToken {
+	token := p.tokens[p.next]
+	if token.kind != tokenEnd {
+		p.next++
+	}
+	return token
+}
+
+func (p *searchParser) parseOr() (searchNode, error) {
+	pos := p.peek().pos
+	nodes := make([]searchNode, 0)
+	for {
+		node, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+
+		if p.peek().kind != tokenOr {
+			break
+		}
+		p.consume()
+	}
+
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	return searchOr{pos: pos, nodes: nodes}, nil
+}
+
+func (p *searchParser) parseAnd() (searchNode, error) {
+	pos := p.peek().pos
+	nodes := make([]searchNode, 0)
+	for {
+		kind := p.peek().kind
+		if kind == tokenEnd || kind == tokenClose || kind == tokenOr {
+			break
+		}
+
+		node, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+
+	switch len(nodes) {
+	case 0:
+		return nil, searchQueryErrorf(pos, "expected a search term")
+	case 1:
+		return nodes[0], nil
+	}
+	return searchAnd{pos: pos, nodes: nodes}, nil
+}
+
+func (p *searchParser) parseUnary() (searchNode, error) {
+	token := p.consume()
+	switch token.kind {
+	case tokenNot:
+		node, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return searchNot{pos: token.pos, node: node}, nil
+
+	case tokenOpen:
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.consume().kind != tokenClose {
+			return nil, searchQueryErrorf(token.pos, "missing closing parenthesis")
+		}
+		return node, nil
+
+	case tokenWord:
+		return searchText{pos: token.pos, text: token.text}, nil
+
+	case tokenPhrase:
+		return searchText{pos: token.pos, text: token.text, phrase: true}, nil
+
+	case tokenFilter:
+		return parseSearchFilter(token, p.now)
+	}
+	return nil, searchQueryErrorf(token.pos, "expected a search term")
+}
+
+var searchStatuses = map[string]string{
+	"open":      "active",
+	"active":    "active",
+	"completed": "completed",
+	"done":      "completed",
+	"overdue":   "overdue",
+}
+
+var searchPriorities = map[string]int{
+	"none":   priorityNone,
+	"low":    priorityLow,
+	"medium": priorityMedium,
+	"high":   priorityHigh,
+	"urgent": priorityUrgent,
+}
+
+var searchRelativeDayRegexp = regexp.MustCompile(`^([+-]?\d{1,4})([dw])$`)
+
+// the comparison prefix of a value and the rest of the value
+func splitSearchOp(value string) (string, string) {
+	for _, op := range []string{"<=", ">=", "<", ">", "="} {
+		if strings.HasPrefix(value, op) {
+			return op, value[len(op):]
+		}
+	}
+	return "=", value
+}
+
+// the start of the day of the value
+func parseSearchDay(value string, now time.Time) (time.Time, bool) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	switch strings.ToLower(value) {
+	case "today":
+		return today, true
+	case "tomorrow":
+		return today.AddDate(0, 0, 1), true
+	case "yesterday":
+		return today.AddDate(0, 0, -1), true
+	case "week":
+		return today.AddDate(0, 0, -(int(today.Weekday())+6)%7), true
+	}
+
+	if m := searchRelativeDayRegexp.FindStringSubmatch(strings.ToLower(value)); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		if m[2] == "w" {
+			n *= 7
+		}
+		return today.AddDate(0, 0, n), true
+	}
+
+	day, err := time.ParseInLocation("2006-01-02", value, now.Location())
+	return day, err == nil
+}
+
+func parseSearchFilter(token searchToken, now time.Time) (searchNode, error) {
+	filter := searchFilter{pos: token.pos, field: token.key, op: "="}
+	if token.text == "" {
+		return nil, searchQueryErrorf(token.valuePos, "missing value of %s", token.key)
+	}
+
+	switch token.key {
+	case "is":
+		status, ok := searchStatuses[strings.ToLower(token.text)]
+		if !ok {
+			return nil, searchQueryErrorf(token.valuePos, "unknown status %q", token.text)
+		}
+		filter.value = status
+		if status == "overdue" {
+			filter.day = now
+		}
+
+	case "list", "label":
+		filter.value = token.text
+
+	case "due", "created", "completed":
+		op, value := splitSearchOp(token.text)
+		filter.op = op
+		if token.key == "due" && op == "=" && strings.ToLower(value) == "none" {
+			break
+		}
+
+		day, ok := parseSearchDay(value, now)
+		if !ok {
+			return nil, searchQueryErrorf(token.valuePos+len(token.text)-len(value), "invalid day %q", value)
+		}
+		filter.day = day
+
+	case "priority":
+		op, value := splitSearchOp(token.text)
+		filter.op = op
+
+		priority, ok := searchPriorities[strings.ToLower(value)]
+		if !ok {
+			n, err := strconv.Atoi(value)
+			if err != nil || !validateTodoItemPriority(n) {
+				return nil, searchQueryErrorf(token.valuePos+len(token.text)-len(value), "invalid priority %q", value)
+			}
+			priority = n
+		}
+		filter.priority = priority
+
+	default:
+		return nil, searchQueryErrorf(token.pos, "unknown operator %q", token.key)
+	}
+	return filter, nil
+}
+
+// parse the query, relative days are from now in its location,
+// the timezone of the account
+func parseSearchQuery(query string, now time.Time) (searchNode, error) {
+	tokens, err := tokenizeSearchQuery([]rune(query))
+	if err != nil {
+		return nil, err
+	}
+
+	p := &searchParser{tokens: tokens, now: now}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if token := p.peek(); token.kind != tokenEnd {
+		return nil, searchQueryErrorf(token.pos, "unexpected closing parenthesis")
+	}
+	return node, nil
+}
+
+// the words of the plain words query, false when the query
+// has phrases, operators, negations or OR
+func searchPlainWords(node searchNode) ([]string, bool) {
+	switch n := node.(type) {
+	case searchText:
+		return []string{n.text}, !n.phrase
+	case searchAnd:
+		words := make([]string, 0, len(n.nodes))
+		for _, child := range n.nodes {
+			text, ok := child.(searchText)
+			if !ok || text.phrase {
+				return nil, false
+			}
+			words = append(words, text.text)
+		}
+		return words, true
+	}
+	return nil, false
+}
+
+// the words and phrases which are not negated, to rank and highlight
+func searchPositiveTexts(node searchNode) []string {
+	switch n := node.(type) {
+	case searchText:
+		return []string{n.text}
+	case searchAnd:
+		texts := make([]string, 0)
+		for _, child := range n.nodes {
+			texts = append(texts, searchPositiveTexts(child)...)
+		}
+		return texts
+	case searchOr:
+		texts := make([]string, 0)
+		for _, child := range n.nodes {
+			texts = append(texts, searchPositiveTexts(child)...)
+		}
+		return texts
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package todo
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSearchQuery(t *testing.T) {
+	now := time.Date(2026, 3, 10, 15, 0, 0, 0, time.UTC)
+	day := func(d int) time.Time {
+		return time.Date(2026, 3, d, 0, 0, 0, 0, time.UTC)
+	}
+
+	table := []struct {
+		query    string
+		expected searchNode
+	}{
+		{"milk", searchText{pos: 0, text: "milk"}},
+		{`is:open list:"Big Groceries" due:<7d label:work "exact phrase"`, searchAnd{pos: 0, nodes: []searchNode{
+			searchFilter{pos: 0, field: "is", op: "=", value: "active"},
+			searchFilter{pos: 8, field: "list", op: "=", value: "Big Groceries"},
+			searchFilter{pos: 29, field: "due", op: "<", day: day(17)},
+			searchFilter{pos: 37, field: "label", op: "=", value: "work"},
+			searchText{pos: 48, text: "exact phrase", phrase: true},
+		}}},
+		{"created:>=2026-01-01 priority:>medium", searchAnd{pos: 0, nodes: []searchNode{
+			searchFilter{pos: 0, field: "created", op: ">=", day: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)},
+			searchFilter{pos: 21, field: "priority", op: ">", priority: priorityMedium},
+		}}},
+		{"-(milk OR eggs) due:none", searchAnd{pos: 0, nodes: []searchNode{
+			searchNot{pos: 0, node: searchOr{pos: 2, nodes: []searchNode{
+				searchText{pos: 2, text: "milk"},
+				searchText{pos: 10, text: "eggs"},
+			}}},
+			searchFilter{pos: 16, field: "due", op: "="},
+		}}},
+		{"is:overdue", searchFilter{pos: 0, field: "is", op: "=", value: "overdue", day: now}},
+	}
+
+	for _, e := range table {
+		actual, err := parseSearchQuery(e.query, now)
+		if err != nil || !reflect.DeepEqual(actual, e.expected) {
+			t.Errorf("%q: expected %+v, actual: %+v %v", e.query, e.expected, actual, err)
+		}
+	}
+}
+
+func TestParseSearchQueryError(t *testing.T) {
+	table := []struct {
+		query string
+		pos   int
+	}{
+		{`milk "eggs`, 5},
+		{"milk (eggs", 5},
+		{"milk eggs)", 9},
+		{"milk OR", 7},
+		{"color:red", 0},
+		{"is:", 3},
+		{"is:later", 3},
+		{"due:<soon", 5},
+		{"priority:9", 9},
+	}
+
+	for _, e := range table {
+		_, err := parseSearchQuery(e.query, time.Now())
+		queryErr, ok := err.(*searchQueryError)
+		if !ok || queryErr.pos != e.pos {
+			t.Errorf("%q: expected an error at %d, actual: %v", e.query, e.pos, err)
+		}
+	}
+}
+
+func TestSearchCondition(t *testing.T) {
+	now := time.Date(2026, 3, 10, 15, 0, 0, 0, time.UTC)
+	node, err := parseSearchQuery(`-"50%" OR created:2026-03-12`, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	where, args := searchCondition(node)
+	expected := "(NOT COALESCE((i.description LIKE ? OR COALESCE(n.body, '') LIKE ?), FALSE)" +
+		" OR (i.created_at >= ? AND i.created_at < ?))"
+	if where != expected {
+		t.Errorf("expected %s, actual: %s", expected, where)
+	}
+
+	expectedArgs := []interface{}{`%50\%%`, `%50\%%`,
+		time.Date(2026, 3, 12, 0, 0, 0, 0, time.UTC),
+		time.Date(2026, 3, 13, 0, 0, 0, 0, time.UTC),
+	}
+	if !reflect.DeepEqual(args, expectedArgs) {
+		t.Errorf("expected %v, actual: %v", expectedArgs, args)
+	}
+}
+
+func TestSearchConditionNegatedFilter(t *testing.T) {
+	now := time.Date(2026, 3, 10, 15, 0, 0, 0, time.UTC)
+	node, err := parseSearchQuery("-due:<7d", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an item without due date is not due before 7 days
+	where, _ := searchCondition(node)
+	expected := "NOT COALESCE(((i.due_all_day = FALSE AND (i.due_at < ?)) OR " +
+		"(i.due_all_day = TRUE AND (i.due_at < ?))), FALSE)"
+	if where != expected {
+		t.Errorf("expected %s, actual: %s", expected, where)
+	}
+}
+
+func TestSearchConditionOverdue(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Skip(err)
+	}
+	// already the 11th in Paris
+	now := time.Date(2026, 3, 11, 0, 30, 0, 0, paris)
+	node, err := parseSearchQuery("is:overdue", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	where, args := searchCondition(node)
+	if !strings.Contains(where, "i.due_all_day = TRUE AND i.due_at < ?") {
+		t.Errorf("all-day items should be overdue the next day, actual: %s", where)
+	}
+	expectedArgs := []interface{}{now, time.Date(2026, 3, 11, 0, 0, 0, 0, time.UTC)}
+	if !reflect.DeepEqual(args, expectedArgs) {
+		t.Errorf("expected %v, actual: %v", expectedArgs, args)
+	}
+}
+
+func TestSearchConditionDueInTimezone(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	// still the 10th in New York
+	now := time.Date(2026, 3, 10, 22, 0, 0, 0, newYork)
+	node, err := parseSearchQuery("due:today", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, args := searchCondition(node)
+	expectedArgs := []interface{}{
+		time.Date(2026, 3, 10, 0, 0, 0, 0, newYork),
+		time.Date(2026, 3, 11, 0, 0, 0, 0, newYork),
+		time.Date(2026, 3, 10, 0, 0, 0, 0, time.UTC),
+		time.Date(2026, 3, 11, 0, 0, 0, 0, time.UTC),
+	}
+	if !reflect.DeepEqual(args, expectedArgs) {
+		t.Errorf("expected %v, actual: %v", expectedArgs, args)
+	}
+}
@@ -2,24 +2,36 @@ package todo
 
 import (
 	"context"
+	"database/sql"
+	"fmt"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/golang/glog"
+	"github.com/golang/protobuf/proto"
 	"github.com/jmoiron/sqlx"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 // Service : gRPC endpoint
 type Service struct {
-	repo *repository
+	repo       *repository
+	pageTokens pageTokenCodec
 }
 
-// NewService : create a new service
-func NewService(db *sqlx.DB) *Service {
+// NewService : create a new service,
+// pageTokenSecret is used to sign the page tokens given to clients
+func NewService(db *sqlx.DB, pageTokenSecret []byte) *Service {
 	repo := newRepository(db)
 	return &Service{
-		repo: repo,
+		repo:       repo,
+		pageTokens: newPageTokenCodec(pageTokenSecret),
 	}
 }
 
@@ -39,6 +51,111 @@ func getAccountID(ctx context.Context) int {
 	return id
 }
 
+// the If-Match header has a form "3" or W/"3", return 0 if there is none
+func getIfMatchVersion(ctx context.Context) (int, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return 0, nil
+	}
+	values := md.Get("grpcgateway-if-match")
+	if len(values) == 0 {
+		return 0, nil
+	}
+
+	etag := strings.TrimPrefix(values[0], "W/")
+	version, err := strconv.Atoi(strings.Trim(etag, `"`))
+	if err != nil || version <= 0 {
+		return 0, errInvalidInput
+	}
+	return version, nil
+}
+
+func versionMismatchError(current ...proto.Message) error {
+	st, err := status.New(codes.FailedPrecondition, errVersionMismatch.Error()).
+		WithDetails(current...)
+	if err != nil {
+		return err
+	}
+	return st.Err()
+}
+
+func todoItemLinesStatusError(err *todoItemLinesError) error {
+	violations := make([]*errdetails.BadRequest_FieldViolation, 0, len(err.lines))
+	for _, l := range err.lines {
+		violations = append(violations, &errdetails.BadRequest_FieldViolation{
+			Field:       "line " + strconv.Itoa(l.line),
+			Description: l.message,
+		})
+	}
+
+	st, detailsErr := status.New(codes.InvalidArgument, err.Error()).
+		WithDetails(&errdetails.BadRequest{FieldViolations: violations})
+	if detailsErr != nil {
+		return detailsErr
+	}
+	return st.Err()
+}
+
+// the status of the error of the operation, with the operation in its message
+func batchStatusError(err *batchOperationError) error {
+	st, ok := status.FromError(toStatusError(err.err))
+	if !ok || st.Code() == codes.Unknown {
+		return err
+	}
+
+	p := st.Proto()
+	p.Message = fmt.Sprintf("operations[%d]: %s", err.index, p.Message)
+	return status.ErrorProto(p)
+}
+
+func toStatusError(err error) error {
+	if queryErr, ok := err.(*searchQueryError); ok {
+		return status.Error(codes.InvalidArgument, queryErr.Error())
+	}
+	if linesErr, ok := err.(*todoItemLinesError); ok {
+		return todoItemLinesStatusError(linesErr)
+	}
+	if batchErr, ok := err.(*batchOperationError); ok {
+		return batchStatusError(batchErr)
+	}
+
+	switch err {
+	case errInvalidInput, errInvalidPosition, errInvalidPageToken:
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errPermissionDenied:
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errAlreadyExisted:
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errTodoListNotEmpty, errVersionMismatch:
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case sql.ErrNoRows:
+		return status.Error(codes.NotFound, "not found")
+	}
+	return err
+}
+
+// UnaryErrorInterceptor : translate the errors of the service to gRPC status codes
+func UnaryErrorInterceptor(
+	ctx context.Context, req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (interface{}, error) {
+	res, err := handler(ctx, req)
+	if err == nil {
+		return res, nil
+	}
+
+	if _, ok := status.FromError(err); ok {
+		return res, err
+	}
+
+	statusErr := toStatusError(err)
+	if statusErr == err {
+		glog.Error(info.FullMethod, ": ", err)
+	}
+	return res, statusErr
+}
+
 // CreateAccount : create a new account
 func (s *Service) CreateAccount(
 	ctx context.Context,
@@ -52,11 +169,30 @@ func (s *Service) CreateAccount(
 	return &CreateAccountResponse{}, err
 }
 
+// UpdateAccountTimezone set the timezone of the account
+func (s *Service) UpdateAccountTimezone(
+	ctx context.Context,
+	in *UpdateAccountTimezoneRequest,
+) (*UpdateAccountTimezoneResponse, error) {
+	accountID := getAccountID(ctx)
+
+	err := s.repo.transact(ctx, func(tx *sqlx.Tx) error {
+		return setAccountTimezone(
+			accountID, in.Timezone,
+			s.repo.updateAccountTimezone(ctx, tx),
+		)
+	})
+	return &UpdateAccountTimezoneResponse{}, err
+}
+
 func domainTodoToDTO(todo todoList) *TodoList {
 	return &TodoList{
 		Id:        int32(todo.id),
 		AccountId: int32(todo.accountID),
 		Name:      todo.name,
+		Position:  todo.position,
+		FolderId:  int32(todo.folderID),
+		Version:   int32(todo.version),
 		CreatedAt: timestamppb.New(todo.createdAt),
 		UpdatedAt: timestamppb.New(todo.updatedAt),
 	}
@@ -69,9 +205,15 @@ func (s *Service) CreateTodoList(
 ) (*CreateTodoListResponse, error) {
 	id := getAccountID(ctx)
 
-	todo, err := createTodoList(id, in.Name,
-		s.repo.saveTodoList(ctx),
-	)
+	var todo todoList
+	err := s.repo.transact(ctx, func(tx *sqlx.Tx) error {
+		tmp, err := createTodoList(id, in.Name,
+			s.repo.getLastTodoListPosition(ctx, tx),
+			s.repo.saveTodoList(ctx, tx),
+		)
+		todo = tmp
+		return err
+	})
 
 	return &CreateTodoListResponse{
 		Todo: domainTodoToDTO(todo),
@@ -85,16 +227,29 @@ func (s *Service) UpdateTodoList(
 ) (*UpdateTodoListResponse, error) {
 	accountID := getAccountID(ctx)
 
+	expectedVersion := int(in.ExpectedVersion)
+	if expectedVersion == 0 {
+		version, err := getIfMatchVersion(ctx)
+		if err != nil {
+			return nil, err
+		}
+		expectedVersion = version
+	}
+
 	var todo todoList
 	err := s.repo.transact(ctx, func(tx *sqlx.Tx) error {
 		tmp, err := updateTodoList(int(
 			in.Id), accountID, in.Name,
+			expectedVersion,
 			s.repo.getTodoList(ctx, tx),
 			s.repo.updateTodoList(ctx, tx),
 		)
 		todo = tmp
 		return err
 	})
+	if err == errVersionMismatch {
+		return nil, versionMismatchError(domainTodoToDTO(todo))
+	}
 
 	return &UpdateTodoListResponse{
 		Todo: domainTodoToDTO(todo),
@@ -107,7 +262,11 @@ func (s *Service) GetTodoList(
 	in *GetTodoListRequest,
 ) (*GetTotoListResponse, error) {
 	accountID := getAccountID(ctx)
-	todos, err := s.repo.getTodoListsByAccount(ctx)(accountID)
+	todos, next, err := selectTodoListsPage(
+		accountID, int(in.PageSize), in.PageToken,
+		s.pageTokens,
+		s.repo.selectTodoListsPageNoTx(ctx),
+	)
 	if err != nil {
 		return &GetTotoListResponse{}, err
 	}
@@ -117,33 +276,223 @@ func (s *Service) GetTodoList(
 		result = append(result, domainTodoToDTO(t))
 	}
 
-	return &GetTotoListResponse{Todos: result}, nil
+	folders, err := s.repo.selectFoldersNoTx(ctx)(accountID)
+	if err != nil {
+		return &GetTotoListResponse{}, err
+	}
+
+	folderResult := make([]*Folder, 0, len(folders))
+	for _, f := range folders {
+		folderResult = append(folderResult, domainFolderToDTO(f))
+	}
+
+	smartLists, err := selectSmartLists(accountID, s.repo.selectSmartListsNoTx(ctx))
+	if err != nil {
+		return &GetTotoListResponse{}, err
+	}
+
+	smartListResult := make([]*SmartList, 0, len(smartLists))
+	for _, l := range smartLists {
+		smartListResult = append(smartListResult, domainSmartListToDTO(l))
+	}
+
+	return &GetTotoListResponse{
+		Todos:         result,
+		NextPageToken: next,
+		Folders:       folderResult,
+		SmartLists:    smartListResult,
+	}, nil
+}
+
+// GetTodoListById get a todo list with statistics of its items
+func (s *Service) GetTodoListById(
+	ctx context.Context,
+	in *GetTodoListByIdRequest,
+) (*GetTodoListByIdResponse, error) {
+	accountID := getAccountID(ctx)
+
+	todo, stats, err := getTodoListWithStats(
+		int(in.Id), accountID,
+		s.repo.getTodoListNoTx(ctx),
+		s.repo.getTodoListStatsNoTx(ctx),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GetTodoListByIdResponse{
+		Todo:               domainTodoToDTO(todo),
+		ActiveItemCount:    int32(stats.activeCount),
+		CompletedItemCount: int32(stats.completedCount),
+		LastActivityAt:     timestamppb.New(stats.lastActivityAt),
+	}, nil
 }
 
-// DeleteTodoList delete a todo list
+// DeleteTodoList delete a todo list together with its items
 func (s *Service) DeleteTodoList(
 	ctx context.Context,
 	in *DeleteTodoListResquest,
 ) (*DeleteTodoListResponse, error) {
 	accountID := getAccountID(ctx)
-	err := s.repo.transact(ctx, func(tx *sqlx.Tx) error {
-		return deleteTodoList(
+
+	var deleted int
+	var err error
+
+	err = s.repo.transact(ctx, func(tx *sqlx.Tx) error {
+		deleted, err = deleteTodoList(
 			int(in.Id), accountID,
+			in.RequireEmpty,
 			s.repo.getTodoList(ctx, tx),
+			s.repo.countTodoListItems(ctx, tx),
+			s.repo.deleteTodoListItems(ctx, tx),
 			s.repo.deleteTodoList(ctx, tx),
 		)
+		return err
+	})
+	return &DeleteTodoListResponse{
+		DeletedItemCount: int32(deleted),
+	}, err
+}
+
+// MoveTodoList place a todo list after or before another one
+func (s *Service) MoveTodoList(
+	ctx context.Context,
+	in *MoveTodoListRequest,
+) (*MoveTodoListResponse, error) {
+	accountID := getAccountID(ctx)
+
+	var todo todoList
+	err := s.repo.transact(ctx, func(tx *sqlx.Tx) error {
+		tmp, err := moveTodoList(
+			int(in.Id), accountID,
+			int(in.AfterId), int(in.BeforeId),
+			s.repo.getTodoList(ctx, tx),
+			s.repo.getNeighbourTodoListPosition(ctx, tx),
+			s.repo.updateTodoListPosition(ctx, tx),
+		)
+		todo = tmp
+		return err
+	})
+
+	return &MoveTodoListResponse{
+		Todo: domainTodoToDTO(todo),
+	}, err
+}
+
+// DuplicateTodoList copy a todo list with its items
+func (s *Service) DuplicateTodoList(
+	ctx context.Context,
+	in *DuplicateTodoListRequest,
+) (*DuplicateTodoListResponse, error) {
+	accountID := getAccountID(ctx)
+
+	var todo todoList
+	var count int
+	var err error
+
+	err = s.repo.transact(ctx, func(tx *sqlx.Tx) error {
+		todo, count, err = duplicateTodoList(
+			int(in.Id), accountID, in.NewName,
+			in.IncludeCompleted, in.ResetCompleted,
+			s.repo.getTodoList(ctx, tx),
+			s.repo.selectTodoItems(ctx, tx),
+			s.repo.getLastTodoListPosition(ctx, tx),
+			s.repo.saveTodoList(ctx, tx),
+			s.repo.insertTodoItems(ctx, tx),
+		)
+		return err
 	})
-	return &DeleteTodoListResponse{}, err
+	return &DuplicateTodoListResponse{
+		Todo:      domainTodoToDTO(todo),
+		ItemCount: int32(count),
+	}, err
 }
 
 func domainTodoItemToDTO(item todoItem) *TodoItem {
 	return &TodoItem{
-		Id:          int32(item.id),
-		TodoListId:  int32(item.todoListID),
-		Description: item.description,
-		Completed:   item.completed,
-		CreatedAt:   timestamppb.New(item.createdAt),
+		Id:                   int32(item.id),
+		TodoListId:           int32(item.todoListID),
+		Description:          item.description,
+		Completed:            item.completed,
+		Position:             item.position,
+		Version:              int32(item.version),
+		DueAt:                formatDueAt(item.dueAt, item.dueAllDay),
+		RemindAt:             timeToDTO(item.remindAt),
+		Recurrence:           item.recurrence,
+		Priority:             TodoItemPriority(item.priority),
+		LabelIds:             idsToDTO(item.labelIDs),
+		ParentItemId:         int32(item.parentID),
+		UpdatedAt:            timestamppb.New(item.updatedAt),
+		CompletedAt:          timeToDTO(item.completedAt),
+		CompletedByAccountId: int32(item.completedBy),
+		CreatedAt:            timestamppb.New(item.createdAt),
+	}
+}
+
+func idsToDTO(ids []int) []int32 {
+	result := make([]int32, 0, len(ids))
+	for _, id := range ids {
+		result = append(result, int32(id))
+	}
+	return result
+}
+
+func idsFromDTO(ids []int32) []int {
+	result := make([]int, 0, len(ids))
+	for _, id := range ids {
+		result = append(result, int(id))
+	}
+	return result
+}
+
+// nil for the zero time
+func timeToDTO(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
 	}
+	return timestamppb.New(t)
+}
+
+// the zero time for nil
+func timeFromDTO(t *timestamppb.Timestamp) (time.Time, error) {
+	if t == nil {
+		return time.Time{}, nil
+	}
+	if err := t.CheckValid(); err != nil {
+		return time.Time{}, errInvalidInput
+	}
+	return t.AsTime(), nil
+}
+
+// the item to be created
+func newTodoItemFromDTO(
+	todoListID int, description string,
+	dueAtDTO string, remindAtDTO *timestamppb.Timestamp, recurrenceDTO string,
+	priority TodoItemPriority, parentID int,
+) (todoItem, error) {
+	dueAt, dueAllDay, err := parseDueAt(dueAtDTO)
+	if err != nil {
+		return todoItem{}, err
+	}
+	remindAt, err := timeFromDTO(remindAtDTO)
+	if err != nil {
+		return todoItem{}, err
+	}
+	recurrence, err := normalizeRecurrence(recurrenceDTO)
+	if err != nil {
+		return todoItem{}, err
+	}
+
+	return todoItem{
+		todoListID:  todoListID,
+		description: description,
+		dueAt:       dueAt,
+		dueAllDay:   dueAllDay,
+		remindAt:    remindAt,
+		recurrence:  recurrence,
+		priority:    int(priority),
+		parentID:    parentID,
+	}, nil
 }
 
 // CreateTodoItem create a todo item
@@ -153,15 +502,22 @@ func (s *Service) CreateTodoItem(
 ) (*CreateTodoItemResponse, error) {
 	accountID := getAccountID(ctx)
 
-	var item todoItem
-	var err error
+	item, err := newTodoItemFromDTO(
+		int(in.TodoListId), in.Description,
+		in.DueAt, in.RemindAt, in.Recurrence,
+		in.Priority, int(in.ParentItemId),
+	)
+	if err != nil {
+		return nil, err
+	}
 
 	err = s.repo.transact(ctx, func(tx *sqlx.Tx) error {
 		item, err = createTodoItem(
-			int(in.TodoListId), accountID,
-			in.Description,
+			accountID, item,
 			s.repo.getTodoList(ctx, tx),
-			s.repo.createTodoItem(ctx, tx),
+			s.repo.getTodoItem(ctx, tx),
+			s.repo.getLastTodoItemPosition(ctx, tx),
+			s.repo.insertTodoItems(ctx, tx),
 		)
 		return err
 	})
@@ -170,6 +526,110 @@ func (s *Service) CreateTodoItem(
 	}, err
 }
 
+// QuickAdd create a todo item from a line of text
+func (s *Service) QuickAdd(
+	ctx context.Context,
+	in *QuickAddRequest,
+) (*QuickAddResponse, error) {
+	accountID := getAccountID(ctx)
+
+	var result quickAddResult
+	var err error
+
+	err = s.repo.transact(ctx, func(tx *sqlx.Tx) error {
+		result, err = quickAddTodoItem(
+			accountID, in.Text, int(in.TodoListId), in.DryRun,
+			time.Now(),
+			s.repo.getAccountTimezone(ctx, tx),
+			s.repo.getTodoListByName(ctx, tx),
+			s.repo.getFirstTodoList(ctx, tx),
+			s.repo.getLastTodoListPosition(ctx, tx),
+			s.repo.saveTodoList(ctx, tx),
+			s.repo.getTodoList(ctx, tx),
+			s.repo.selectLabels(ctx, tx),
+			s.repo.saveLabel(ctx, tx),
+			s.repo.getTodoItem(ctx, tx),
+			s.repo.getLastTodoItemPosition(ctx, tx),
+			s.repo.insertTodoItems(ctx, tx),
+		)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &QuickAddResponse{
+		Item:          domainTodoItemToDTO(result.item),
+		LabelNames:    result.labelNames,
+		NewLabelNames: result.newLabelNames,
+	}, nil
+}
+
+// CreateTodoItems create many todo items at the end of a list
+func (s *Service) CreateTodoItems(
+	ctx context.Context,
+	in *CreateTodoItemsRequest,
+) (*CreateTodoItemsResponse, error) {
+	accountID := getAccountID(ctx)
+
+	var items []todoItem
+	var err error
+
+	err = s.repo.transact(ctx, func(tx *sqlx.Tx) error {
+		items, err = createTodoItems(
+			accountID, int(in.TodoListId), in.Descriptions, in.Text,
+			time.Now(),
+			s.repo.getTodoList(ctx, tx),
+			s.repo.getLastTodoItemPosition(ctx, tx),
+			s.repo.insertTodoItems(ctx, tx),
+		)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*TodoItem, 0, len(items))
+	for _, item := range items {
+		result = append(result, domainTodoItemToDTO(item))
+	}
+
+	return &CreateTodoItemsResponse{Items: result}, nil
+}
+
+func todoItemQueryFromDTO(in *GetTodoItemsRequest, now time.Time) (todoItemQuery, error) {
+	query := todoItemQuery{
+		todoListID: int(in.TodoListId),
+		sortBy:     todoItemSort(in.SortBy),
+		labelIDs:   idsFromDTO(in.LabelIds),
+		status:     todoItemStatus(in.Status),
+		text:       in.Text,
+	}
+
+	var err error
+	query.dueAfter, _, err = parseDueAt(in.DueAfter)
+	if err != nil {
+		return query, err
+	}
+	query.dueBefore, _, err = parseDueAt(in.DueBefore)
+	if err != nil {
+		return query, err
+	}
+	query.createdAfter, err = timeFromDTO(in.CreatedAfter)
+	if err != nil {
+		return query, err
+	}
+	query.createdBefore, err = timeFromDTO(in.CreatedBefore)
+	if err != nil {
+		return query, err
+	}
+
+	if in.OverdueOnly {
+		query.overdueAt = now
+	}
+	return query, nil
+}
+
 // GetTodoItems return list of todo items
 func (s *Service) GetTodoItems(
 	ctx context.Context,
@@ -177,10 +637,23 @@ func (s *Service) GetTodoItems(
 ) (*GetTodoItemsResponse, error) {
 	accountID := getAccountID(ctx)
 
-	items, err := selectTodoItems(
-		int(in.TodoListId), accountID,
+	now, err := accountNow(accountID, time.Now(),
+		s.repo.getAccountTimezone(ctx, s.repo.db))
+	if err != nil {
+		return nil, err
+	}
+
+	query, err := todoItemQueryFromDTO(in, now)
+	if err != nil {
+		return nil, err
+	}
+
+	items, next, err := selectTodoItemsPage(
+		query, accountID,
+		int(in.PageSize), in.PageToken,
+		s.pageTokens,
 		s.repo.getTodoListNoTx(ctx),
-		s.repo.selectTodoItemsNoTx(ctx),
+		s.repo.selectTodoItemsPageNoTx(ctx),
 	)
 	if err != nil {
 		return nil, err
@@ -192,7 +665,8 @@ func (s *Service) GetTodoItems(
 	}
 
 	return &GetTodoItemsResponse{
-		TodoItems: result,
+		TodoItems:     result,
+		NextPageToken: next,
 	}, nil
 }
 
@@ -213,32 +687,1105 @@ func (s *Service) UpdateTodoItemsCompleted(
 		toBeActive = append(toBeActive, int(e))
 	}
 
-	err := s.repo.transact(ctx, func(tx *sqlx.Tx) error {
-		return updateTodoItemsCompleted(
+	expectedVersions := make(map[int]int)
+	for id, version := range in.ExpectedVersions {
+		expectedVersions[int(id)] = int(version)
+	}
+
+	var created, stale []todoItem
+	var err error
+
+	err = s.repo.transact(ctx, func(tx *sqlx.Tx) error {
+		loc, err := accountLocation(accountID, s.repo.getAccountTimezone(ctx, tx))
+		if err != nil {
+			return err
+		}
+
+		created, stale, err = updateTodoItemsCompleted(
 			int(in.TodoListId), accountID,
 			toBeCompleted, toBeActive,
+			in.CompleteSubtasks,
+			expectedVersions, loc,
 			s.repo.getTodoList(ctx, tx),
 			s.repo.selectTodoItems(ctx, tx),
 			s.repo.updateTodoItemsCompleted(ctx, tx),
+			s.repo.getLastTodoItemPosition(ctx, tx),
+			s.repo.insertTodoItems(ctx, tx),
 		)
+		return err
 	})
-	return &UpdateTodoItemsCompletedResponse{}, err
+	if err == errVersionMismatch {
+		current := make([]proto.Message, 0, len(stale))
+		for _, item := range stale {
+			current = append(current, domainTodoItemToDTO(item))
+		}
+		return nil, versionMismatchError(current...)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*TodoItem, 0, len(created))
+	for _, item := range created {
+		result = append(result, domainTodoItemToDTO(item))
+	}
+	return &UpdateTodoItemsCompletedResponse{CreatedItems: result}, nil
 }
 
-// DeleteTodoItemsCompleted delete completed todo items
-func (s *Service) DeleteTodoItemsCompleted(
+// GetTodoItem return a todo item with its notes
+func (s *Service) GetTodoItem(
 	ctx context.Context,
-	in *DeleteTodoItemsCompletedRequest,
-) (*DeleteTodoItemsCompletedResponse, error) {
+	in *GetTodoItemRequest,
+) (*GetTodoItemResponse, error) {
 	accountID := getAccountID(ctx)
 
-	err := s.repo.transact(ctx, func(tx *sqlx.Tx) error {
-		return deleteTodoItemsCompleted(
-			int(in.TodoListId), accountID,
+	item, notes, err := getTodoItemDetail(
+		int(in.Id), accountID,
+		s.repo.getTodoListNoTx(ctx),
+		s.repo.getTodoItemNoTx(ctx),
+		s.repo.getTodoItemNotesNoTx(ctx),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &GetTodoItemResponse{
+		Item:  domainTodoItemToDTO(item),
+		Notes: notes,
+	}
+	if in.RenderHtml {
+		res.NotesHtml = renderMarkdown(notes)
+	}
+	return res, nil
+}
+
+// UpdateTodoItemNotes replace the notes of a todo item
+func (s *Service) UpdateTodoItemNotes(
+	ctx context.Context,
+	in *UpdateTodoItemNotesRequest,
+) (*UpdateTodoItemNotesResponse, error) {
+	accountID := getAccountID(ctx)
+
+	expectedVersion := int(in.ExpectedVersion)
+	var err error
+	if expectedVersion == 0 {
+		expectedVersion, err = getIfMatchVersion(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var item todoItem
+	err = s.repo.transact(ctx, func(tx *sqlx.Tx) error {
+		item, err = updateTodoItemNotes(
+			int(in.Id), accountID, in.Notes,
+			expectedVersion,
 			s.repo.getTodoList(ctx, tx),
-			s.repo.deleteTodoItemsCompleted(ctx, tx),
+			s.repo.getTodoItem(ctx, tx),
+			s.repo.updateTodoItem(ctx, tx),
+			s.repo.saveTodoItemNotes(ctx, tx),
 		)
+		return err
 	})
+	if err == errVersionMismatch {
+		return nil, versionMismatchError(domainTodoItemToDTO(item))
+	}
 
-	return &DeleteTodoItemsCompletedResponse{}, err
+	return &UpdateTodoItemNotesResponse{
+		Item: domainTodoItemToDTO(item),
+	}, err
+}
+
+// SkipTodoItemOccurrence move a recurring item to its next occurrence
+func (s *Service) SkipTodoItemOccurrence(
+	ctx context.Context,
+	in *SkipTodoItemOccurrenceRequest,
+) (*SkipTodoItemOccurrenceResponse, error) {
+	accountID := getAccountID(ctx)
+
+	expectedVersion := int(in.ExpectedVersion)
+	var err error
+	if expectedVersion == 0 {
+		expectedVersion, err = getIfMatchVersion(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var item todoItem
+	err = s.repo.transact(ctx, func(tx *sqlx.Tx) error {
+		loc, err := accountLocation(accountID, s.repo.getAccountTimezone(ctx, tx))
+		if err != nil {
+			return err
+		}
+
+		item, err = skipTodoItemOccurrence(
+			int(in.Id), accountID, expectedVersion, loc,
+			s.repo.getTodoList(ctx, tx),
+			s.repo.getTodoItem(ctx, tx),
+			s.repo.updateTodoItem(ctx, tx),
+		)
+		return err
+	})
+	if err == errVersionMismatch {
+		return nil, versionMismatchError(domainTodoItemToDTO(item))
+	}
+
+	return &SkipTodoItemOccurrenceResponse{
+		Item: domainTodoItemToDTO(item),
+	}, err
+}
+
+// an empty mask updates every updatable field
+func todoItemChangesFromDTO(item *TodoItem, paths []string) (todoItemChanges, error) {
+	if len(paths) == 0 {
+		paths = []string{
+			"description", "completed",
+			"due_at", "remind_at", "recurrence", "priority",
+		}
+	}
+
+	changes := todoItemChanges{}
+	for _, path := range paths {
+		switch path {
+		case "description":
+			changes.setDescription = true
+			changes.description = item.GetDescription()
+		case "completed":
+			changes.setCompleted = true
+			changes.completed = item.GetCompleted()
+		case "due_at":
+			dueAt, dueAllDay, err := parseDueAt(item.GetDueAt())
+			if err != nil {
+				return changes, err
+			}
+			changes.setDueAt = true
+			changes.dueAt = dueAt
+			changes.dueAllDay = dueAllDay
+		case "remind_at":
+			remindAt, err := timeFromDTO(item.GetRemindAt())
+			if err != nil {
+				return changes, err
+			}
+			changes.setRemindAt = true
+			changes.remindAt = remindAt
+		case "recurrence":
+			recurrence, err := normalizeRecurrence(item.GetRecurrence())
+			if err != nil {
+				return changes, err
+			}
+			changes.setRecurrence = true
+			changes.recurrence = recurrence
+		case "priority":
+			changes.setPriority = true
+			changes.priority = int(item.GetPriority())
+		default:
+			return changes, errInvalidInput
+		}
+	}
+	return changes, nil
+}
+
+// UpdateTodoItem update the fields of a todo item given by the update mask
+func (s *Service) UpdateTodoItem(
+	ctx context.Context,
+	in *UpdateTodoItemRequest,
+) (*UpdateTodoItemResponse, error) {
+	accountID := getAccountID(ctx)
+
+	changes, err := todoItemChangesFromDTO(in.Item, in.UpdateMask.GetPaths())
+	if err != nil {
+		return nil, err
+	}
+
+	expectedVersion := int(in.ExpectedVersion)
+	if expectedVersion == 0 {
+		expectedVersion, err = getIfMatchVersion(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var item todoItem
+	var created []todoItem
+	err = s.repo.transact(ctx, func(tx *sqlx.Tx) error {
+		loc, err := accountLocation(accountID, s.repo.getAccountTimezone(ctx, tx))
+		if err != nil {
+			return err
+		}
+
+		item, created, err = updateTodoItem(
+			int(in.Id), accountID,
+			changes, expectedVersion, loc,
+			s.repo.getTodoList(ctx, tx),
+			s.repo.getTodoItem(ctx, tx),
+			s.repo.updateTodoItem(ctx, tx),
+			s.repo.getLastTodoItemPosition(ctx, tx),
+			s.repo.insertTodoItems(ctx, tx),
+		)
+		return err
+	})
+	if err == errVersionMismatch {
+		return nil, versionMismatchError(domainTodoItemToDTO(item))
+	}
+
+	result := make([]*TodoItem, 0, len(created))
+	for _, c := range created {
+		result = append(result, domainTodoItemToDTO(c))
+	}
+	return &UpdateTodoItemResponse{
+		Item:         domainTodoItemToDTO(item),
+		CreatedItems: result,
+	}, err
+}
+
+// DeleteTodoItem delete a todo item
+func (s *Service) DeleteTodoItem(
+	ctx context.Context,
+	in *DeleteTodoItemRequest,
+) (*DeleteTodoItemResponse, error) {
+	accountID := getAccountID(ctx)
+
+	err := s.repo.transact(ctx, func(tx *sqlx.Tx) error {
+		return deleteTodoItem(
+			int(in.Id), accountID,
+			s.repo.getTodoList(ctx, tx),
+			s.repo.getTodoItem(ctx, tx),
+			s.repo.deleteTodoItem(ctx, tx),
+		)
+	})
+	return &DeleteTodoItemResponse{}, err
+}
+
+// DeleteTodoItemsCompleted delete completed todo items
+func (s *Service) DeleteTodoItemsCompleted(
+	ctx context.Context,
+	in *DeleteTodoItemsCompletedRequest,
+) (*DeleteTodoItemsCompletedResponse, error) {
+	accountID := getAccountID(ctx)
+
+	err := s.repo.transact(ctx, func(tx *sqlx.Tx) error {
+		return deleteTodoItemsCompleted(
+			int(in.TodoListId), accountID,
+			s.repo.getTodoList(ctx, tx),
+			s.repo.selectTodoItems(ctx, tx),
+			s.repo.deleteTodoItems(ctx, tx),
+		)
+	})
+
+	return &DeleteTodoItemsCompletedResponse{}, err
+}
+
+// MoveTodoItem place a todo item after or before another one
+func (s *Service) MoveTodoItem(
+	ctx context.Context,
+	in *MoveTodoItemRequest,
+) (*MoveTodoItemResponse, error) {
+	accountID := getAccountID(ctx)
+
+	var item todoItem
+	var err error
+
+	err = s.repo.transact(ctx, func(tx *sqlx.Tx) error {
+		item, err = moveTodoItem(
+			int(in.Id), accountID,
+			int(in.AfterId), int(in.BeforeId),
+			s.repo.getTodoList(ctx, tx),
+			s.repo.getTodoItem(ctx, tx),
+			s.repo.getNeighbourTodoItemPosition(ctx, tx),
+			s.repo.updateTodoItemPosition(ctx, tx),
+		)
+		return err
+	})
+	return &MoveTodoItemResponse{
+		Item: domainTodoItemToDTO(item),
+	}, err
+}
+
+// MoveTodoItems move todo items into another todo list
+func (s *Service) MoveTodoItems(
+	ctx context.Context,
+	in *MoveTodoItemsRequest,
+) (*MoveTodoItemsResponse, error) {
+	accountID := getAccountID(ctx)
+
+	ids := make([]int, 0, len(in.ItemIds))
+	for _, id := range in.ItemIds {
+		ids = append(ids, int(id))
+	}
+
+	var items []todoItem
+	var err error
+
+	err = s.repo.transact(ctx, func(tx *sqlx.Tx) error {
+		items, err = moveTodoItems(
+			ids, int(in.TargetListId), accountID,
+			int(in.AfterId), int(in.BeforeId),
+			s.repo.getTodoList(ctx, tx),
+			s.repo.getTodoItem(ctx, tx),
+			s.repo.selectTodoItemChildren(ctx, tx),
+			s.repo.getNeighbourTodoItemPosition(ctx, tx),
+			s.repo.getLastTodoItemPosition(ctx, tx),
+			s.repo.moveTodoItems(ctx, tx),
+		)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*TodoItem, 0, len(items))
+	for _, item := range items {
+		result = append(result, domainTodoItemToDTO(item))
+	}
+	return &MoveTodoItemsResponse{Items: result}, nil
+}
+
+func domainTemplateToDTO(template todoTemplate) *Template {
+	items := make([]*TemplateItem, 0, len(template.items))
+	for _, item := range template.items {
+		items = append(items, &TemplateItem{
+			Description: item.description,
+			Position:    item.position,
+		})
+	}
+
+	return &Template{
+		Id:        int32(template.id),
+		AccountId: int32(template.accountID),
+		Name:      template.name,
+		Items:     items,
+		CreatedAt: timestamppb.New(template.createdAt),
+	}
+}
+
+// CreateTemplateFromList create a template from the items of a todo list
+func (s *Service) CreateTemplateFromList(
+	ctx context.Context,
+	in *CreateTemplateFromListRequest,
+) (*CreateTemplateFromListResponse, error) {
+	accountID := getAccountID(ctx)
+
+	var template todoTemplate
+	var err error
+
+	err = s.repo.transact(ctx, func(tx *sqlx.Tx) error {
+		template, err = createTemplateFromList(
+			int(in.TodoListId), accountID, in.Name,
+			s.repo.getTodoList(ctx, tx),
+			s.repo.selectTodoItems(ctx, tx),
+			s.repo.saveTodoTemplate(ctx, tx),
+			s.repo.insertTodoTemplateItems(ctx, tx),
+		)
+		return err
+	})
+	return &CreateTemplateFromListResponse{
+		Template: domainTemplateToDTO(template),
+	}, err
+}
+
+// ListTemplates return the templates owned by or shared with the user
+func (s *Service) ListTemplates(
+	ctx context.Context,
+	in *ListTemplatesRequest,
+) (*ListTemplatesResponse, error) {
+	accountID := getAccountID(ctx)
+
+	templates, err := s.repo.selectTodoTemplatesNoTx(ctx)(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*Template, 0, len(templates))
+	for _, t := range templates {
+		result = append(result, domainTemplateToDTO(t))
+	}
+
+	return &ListTemplatesResponse{Templates: result}, nil
+}
+
+// InstantiateTemplate create a todo list from a template
+func (s *Service) InstantiateTemplate(
+	ctx context.Context,
+	in *InstantiateTemplateRequest,
+) (*InstantiateTemplateResponse, error) {
+	accountID := getAccountID(ctx)
+
+	var todo todoList
+	var count int
+	var err error
+
+	err = s.repo.transact(ctx, func(tx *sqlx.Tx) error {
+		todo, count, err = instantiateTemplate(
+			int(in.TemplateId), accountID, in.Name,
+			in.Values, time.Now(),
+			s.repo.getTodoTemplate(ctx, tx),
+			s.repo.isTodoTemplateShared(ctx, tx),
+			s.repo.getLastTodoListPosition(ctx, tx),
+			s.repo.saveTodoList(ctx, tx),
+			s.repo.insertTodoItems(ctx, tx),
+		)
+		return err
+	})
+	return &InstantiateTemplateResponse{
+		Todo:      domainTodoToDTO(todo),
+		ItemCount: int32(count),
+	}, err
+}
+
+// ShareTemplate share a template with another user
+func (s *Service) ShareTemplate(
+	ctx context.Context,
+	in *ShareTemplateRequest,
+) (*ShareTemplateResponse, error) {
+	accountID := getAccountID(ctx)
+
+	err := s.repo.transact(ctx, func(tx *sqlx.Tx) error {
+		return shareTemplate(
+			int(in.TemplateId), accountID, in.Username,
+			s.repo.getTodoTemplate(ctx, tx),
+			s.repo.getAccountIDByUsername(ctx, tx),
+			s.repo.shareTodoTemplate(ctx, tx),
+		)
+	})
+	return &ShareTemplateResponse{}, err
+}
+
+// UnshareTemplate stop sharing a template with another user
+func (s *Service) UnshareTemplate(
+	ctx context.Context,
+	in *UnshareTemplateRequest,
+) (*UnshareTemplateResponse, error) {
+	accountID := getAccountID(ctx)
+
+	err := s.repo.transact(ctx, func(tx *sqlx.Tx) error {
+		return unshareTemplate(
+			int(in.TemplateId), accountID, in.Username,
+			s.repo.getTodoTemplate(ctx, tx),
+			s.repo.getAccountIDByUsername(ctx, tx),
+			s.repo.unshareTodoTemplate(ctx, tx),
+		)
+	})
+	return &UnshareTemplateResponse{}, err
+}
+
+// DeleteTemplate delete a template
+func (s *Service) DeleteTemplate(
+	ctx context.Context,
+	in *DeleteTemplateRequest,
+) (*DeleteTemplateResponse, error) {
+	accountID := getAccountID(ctx)
+
+	err := s.repo.transact(ctx, func(tx *sqlx.Tx) error {
+		return deleteTemplate(
+			int(in.TemplateId), accountID,
+			s.repo.getTodoTemplate(ctx, tx),
+			s.repo.deleteTodoTemplate(ctx, tx),
+		)
+	})
+	return &DeleteTemplateResponse{}, err
+}
+
+func domainFolderToDTO(f folder) *Folder {
+	return &Folder{
+		Id:        int32(f.id),
+		AccountId: int32(f.accountID),
+		ParentId:  int32(f.parentID),
+		Name:      f.name,
+		Position:  f.position,
+		CreatedAt: timestamppb.New(f.createdAt),
+		UpdatedAt: timestamppb.New(f.updatedAt),
+	}
+}
+
+// CreateFolder create a folder, inside another one if parent_id is set
+func (s *Service) CreateFolder(
+	ctx context.Context,
+	in *CreateFolderRequest,
+) (*CreateFolderResponse, error) {
+	accountID := getAccountID(ctx)
+
+	var f folder
+	var err error
+
+	err = s.repo.transact(ctx, func(tx *sqlx.Tx) error {
+		f, err = createFolder(
+			accountID, int(in.ParentId), in.Name,
+			s.repo.getFolder(ctx, tx),
+			s.repo.getLastFolderPosition(ctx, tx),
+			s.repo.saveFolder(ctx, tx),
+		)
+		return err
+	})
+	return &CreateFolderResponse{
+		Folder: domainFolderToDTO(f),
+	}, err
+}
+
+// RenameFolder update the name of a folder
+func (s *Service) RenameFolder(
+	ctx context.Context,
+	in *RenameFolderRequest,
+) (*RenameFolderResponse, error) {
+	accountID := getAccountID(ctx)
+
+	var f folder
+	var err error
+
+	err = s.repo.transact(ctx, func(tx *sqlx.Tx) error {
+		f, err = renameFolder(
+			int(in.Id), accountID, in.Name,
+			s.repo.getFolder(ctx, tx),
+			s.repo.renameFolder(ctx, tx),
+		)
+		return err
+	})
+	return &RenameFolderResponse{
+		Folder: domainFolderToDTO(f),
+	}, err
+}
+
+// DeleteFolder delete a folder, keeping its todo lists
+func (s *Service) DeleteFolder(
+	ctx context.Context,
+	in *DeleteFolderRequest,
+) (*DeleteFolderResponse, error) {
+	accountID := getAccountID(ctx)
+
+	err := s.repo.transact(ctx, func(tx *sqlx.Tx) error {
+		return deleteFolder(
+			int(in.Id), accountID,
+			s.repo.getFolder(ctx, tx),
+			s.repo.deleteFolder(ctx, tx),
+		)
+	})
+	return &DeleteFolderResponse{}, err
+}
+
+// MoveListToFolder put a todo list into a folder
+func (s *Service) MoveListToFolder(
+	ctx context.Context,
+	in *MoveListToFolderRequest,
+) (*MoveListToFolderResponse, error) {
+	accountID := getAccountID(ctx)
+
+	var todo todoList
+	var err error
+
+	err = s.repo.transact(ctx, func(tx *sqlx.Tx) error {
+		todo, err = moveTodoListToFolder(
+			int(in.TodoListId), accountID, int(in.FolderId),
+			s.repo.getTodoList(ctx, tx),
+			s.repo.getFolder(ctx, tx),
+			s.repo.updateTodoListFolder(ctx, tx),
+		)
+		return err
+	})
+	return &MoveListToFolderResponse{
+		Todo: domainTodoToDTO(todo),
+	}, err
+}
+
+func domainLabelToDTO(l label) *Label {
+	return &Label{
+		Id:        int32(l.id),
+		Name:      l.name,
+		Color:     l.color,
+		CreatedAt: timestamppb.New(l.createdAt),
+	}
+}
+
+// CreateLabel create a label
+func (s *Service) CreateLabel(
+	ctx context.Context,
+	in *CreateLabelRequest,
+) (*CreateLabelResponse, error) {
+	accountID := getAccountID(ctx)
+
+	var l label
+	var err error
+
+	err = s.repo.transact(ctx, func(tx *sqlx.Tx) error {
+		l, err = createLabel(
+			accountID, in.Name, in.Color,
+			s.repo.selectLabels(ctx, tx),
+			s.repo.saveLabel(ctx, tx),
+		)
+		return err
+	})
+	return &CreateLabelResponse{
+		Label: domainLabelToDTO(l),
+	}, err
+}
+
+// GetLabels return the labels of the account
+func (s *Service) GetLabels(
+	ctx context.Context,
+	in *GetLabelsRequest,
+) (*GetLabelsResponse, error) {
+	accountID := getAccountID(ctx)
+
+	labels, err := s.repo.selectLabels(ctx, s.repo.db)(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*Label, 0, len(labels))
+	for _, l := range labels {
+		result = append(result, domainLabelToDTO(l))
+	}
+	return &GetLabelsResponse{Labels: result}, nil
+}
+
+// UpdateLabel update the name and the colour of a label
+func (s *Service) UpdateLabel(
+	ctx context.Context,
+	in *UpdateLabelRequest,
+) (*UpdateLabelResponse, error) {
+	accountID := getAccountID(ctx)
+
+	var l label
+	var err error
+
+	err = s.repo.transact(ctx, func(tx *sqlx.Tx) error {
+		l, err = updateLabel(
+			int(in.Id), accountID, in.Name, in.Color,
+			s.repo.getLabel(ctx, tx),
+			s.repo.selectLabels(ctx, tx),
+			s.repo.updateLabel(ctx, tx),
+		)
+		return err
+	})
+	return &UpdateLabelResponse{
+		Label: domainLabelToDTO(l),
+	}, err
+}
+
+// DeleteLabel delete a label, removing it from its items
+func (s *Service) DeleteLabel(
+	ctx context.Context,
+	in *DeleteLabelRequest,
+) (*DeleteLabelResponse, error) {
+	accountID := getAccountID(ctx)
+
+	err := s.repo.transact(ctx, func(tx *sqlx.Tx) error {
+		return deleteLabel(
+			int(in.Id), accountID,
+			s.repo.getLabel(ctx, tx),
+			s.repo.deleteLabel(ctx, tx),
+		)
+	})
+	return &DeleteLabelResponse{}, err
+}
+
+// GetLabelItems return the items with a label across all todo lists
+func (s *Service) GetLabelItems(
+	ctx context.Context,
+	in *GetLabelItemsRequest,
+) (*GetLabelItemsResponse, error) {
+	accountID := getAccountID(ctx)
+
+	items, next, err := selectLabelItemsPage(
+		int(in.LabelId), accountID,
+		int(in.PageSize), in.PageToken,
+		s.pageTokens,
+		s.repo.getLabel(ctx, s.repo.db),
+		s.repo.selectLabelItemsPageNoTx(ctx),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*TodoItem, 0, len(items))
+	for _, item := range items {
+		result = append(result, domainTodoItemToDTO(item))
+	}
+
+	return &GetLabelItemsResponse{
+		TodoItems:     result,
+		NextPageToken: next,
+	}, nil
+}
+
+// AttachTodoItemLabels add labels to a todo item
+func (s *Service) AttachTodoItemLabels(
+	ctx context.Context,
+	in *AttachTodoItemLabelsRequest,
+) (*AttachTodoItemLabelsResponse, error) {
+	accountID := getAccountID(ctx)
+
+	var item todoItem
+	var err error
+
+	err = s.repo.transact(ctx, func(tx *sqlx.Tx) error {
+		item, err = attachTodoItemLabels(
+			int(in.Id), accountID, idsFromDTO(in.LabelIds),
+			s.repo.getTodoList(ctx, tx),
+			s.repo.getTodoItem(ctx, tx),
+			s.repo.getLabel(ctx, tx),
+			s.repo.attachTodoItemLabels(ctx, tx),
+		)
+		return err
+	})
+	return &AttachTodoItemLabelsResponse{
+		Item: domainTodoItemToDTO(item),
+	}, err
+}
+
+// DetachTodoItemLabel remove a label from a todo item
+func (s *Service) DetachTodoItemLabel(
+	ctx context.Context,
+	in *DetachTodoItemLabelRequest,
+) (*DetachTodoItemLabelResponse, error) {
+	accountID := getAccountID(ctx)
+
+	var item todoItem
+	var err error
+
+	err = s.repo.transact(ctx, func(tx *sqlx.Tx) error {
+		item, err = detachTodoItemLabel(
+			int(in.Id), accountID, int(in.LabelId),
+			s.repo.getTodoList(ctx, tx),
+			s.repo.getTodoItem(ctx, tx),
+			s.repo.detachTodoItemLabel(ctx, tx),
+		)
+		return err
+	})
+	return &DetachTodoItemLabelResponse{
+		Item: domainTodoItemToDTO(item),
+	}, err
+}
+
+func domainSearchResultToDTO(r searchResult) *SearchResult {
+	highlights := make([]*SearchHighlight, 0, len(r.highlights))
+	for _, h := range r.highlights {
+		highlights = append(highlights, &SearchHighlight{
+			Field:   h.field,
+			Snippet: h.snippet,
+		})
+	}
+
+	result := &SearchResult{Highlights: highlights}
+	if r.list.id != 0 {
+		result.Result = &SearchResult_TodoList{TodoList: domainTodoToDTO(r.list)}
+	} else {
+		result.Result = &SearchResult_TodoItem{TodoItem: domainTodoItemToDTO(r.item)}
+	}
+	return result
+}
+
+// Search find the todo lists and items of the account matching a query
+func (s *Service) Search(
+	ctx context.Context,
+	in *SearchRequest,
+) (*SearchResponse, error) {
+	accountID := getAccountID(ctx)
+
+	now, err := accountNow(accountID, time.Now(),
+		s.repo.getAccountTimezone(ctx, s.repo.db))
+	if err != nil {
+		return nil, err
+	}
+
+	results, next, err := search(
+		accountID, in.Query, now,
+		int(in.PageSize), in.PageToken,
+		s.pageTokens,
+		s.repo.selectSearchHitsNoTx(ctx),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	dtos := make([]*SearchResult, 0, len(results))
+	for _, r := range results {
+		dtos = append(dtos, domainSearchResultToDTO(r))
+	}
+
+	return &SearchResponse{
+		Results:       dtos,
+		NextPageToken: next,
+	}, nil
+}
+
+func domainSmartListToDTO(l smartList) *SmartList {
+	result := &SmartList{
+		Id:      int32(l.id),
+		Name:    l.name,
+		Query:   l.query,
+		Builtin: l.id < 0,
+	}
+	if !l.createdAt.IsZero() {
+		result.CreatedAt = timestamppb.New(l.createdAt)
+	}
+	return result
+}
+
+// CreateSmartList save a search query as a smart list
+func (s *Service) CreateSmartList(
+	ctx context.Context,
+	in *CreateSmartListRequest,
+) (*CreateSmartListResponse, error) {
+	accountID := getAccountID(ctx)
+
+	var l smartList
+	var err error
+
+	err = s.repo.transact(ctx, func(tx *sqlx.Tx) error {
+		l, err = createSmartList(
+			accountID, in.Name, in.Query,
+			s.repo.saveSmartList(ctx, tx),
+		)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &CreateSmartListResponse{SmartList: domainSmartListToDTO(l)}, nil
+}
+
+// UpdateSmartList change the name and the query of a smart list
+func (s *Service) UpdateSmartList(
+	ctx context.Context,
+	in *UpdateSmartListRequest,
+) (*UpdateSmartListResponse, error) {
+	accountID := getAccountID(ctx)
+
+	var l smartList
+	var err error
+
+	err = s.repo.transact(ctx, func(tx *sqlx.Tx) error {
+		l, err = updateSmartList(
+			int(in.Id), accountID, in.Name, in.Query,
+			s.repo.getSmartList(ctx, tx),
+			s.repo.updateSmartList(ctx, tx),
+		)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &UpdateSmartListResponse{SmartList: domainSmartListToDTO(l)}, nil
+}
+
+// DeleteSmartList delete a smart list, its items are kept
+func (s *Service) DeleteSmartList(
+	ctx context.Context,
+	in *DeleteSmartListRequest,
+) (*DeleteSmartListResponse, error) {
+	accountID := getAccountID(ctx)
+
+	err := s.repo.transact(ctx, func(tx *sqlx.Tx) error {
+		return deleteSmartList(
+			int(in.Id), accountID,
+			s.repo.getSmartList(ctx, tx),
+			s.repo.deleteSmartList(ctx, tx),
+		)
+	})
+	return &DeleteSmartListResponse{}, err
+}
+
+// GetSmartListItems get a page of the items matching a smart list
+func (s *Service) GetSmartListItems(
+	ctx context.Context,
+	in *GetSmartListItemsRequest,
+) (*GetSmartListItemsResponse, error) {
+	accountID := getAccountID(ctx)
+
+	now, err := accountNow(accountID, time.Now(),
+		s.repo.getAccountTimezone(ctx, s.repo.db))
+	if err != nil {
+		return nil, err
+	}
+
+	l, items, next, err := selectSmartListItemsPage(
+		int(in.Id), accountID, now,
+		int(in.PageSize), in.PageToken,
+		s.pageTokens,
+		s.repo.getSmartList(ctx, s.repo.db),
+		s.repo.selectSmartListItemsPageNoTx(ctx),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*TodoItem, 0, len(items))
+	for _, item := range items {
+		result = append(result, domainTodoItemToDTO(item))
+	}
+
+	return &GetSmartListItemsResponse{
+		SmartList:     domainSmartListToDTO(l),
+		TodoItems:     result,
+		NextPageToken: next,
+	}, nil
+}
+
+// the operation run with the closures of the transaction
+func (s *Service) batchOperation(
+	ctx context.Context, tx *sqlx.Tx,
+	accountID int, op *BatchOperation,
+) batchOperation {
+	run := func(ids batchIDs) (batchResult, error) {
+		switch o := op.Operation.(type) {
+		case *BatchOperation_CreateTodoList:
+			list, err := createTodoList(
+				accountID, o.CreateTodoList.Name,
+				s.repo.getLastTodoListPosition(ctx, tx),
+				s.repo.saveTodoList(ctx, tx),
+			)
+			return batchResult{list: list}, err
+
+		case *BatchOperation_RenameTodoList:
+			in := o.RenameTodoList
+			id, err := ids.resolve(batchList, int(in.Id), in.IdRef)
+			if err != nil {
+				return batchResult{}, err
+			}
+
+			list, err := updateTodoList(
+				id, accountID, in.Name, int(in.ExpectedVersion),
+				s.repo.getTodoList(ctx, tx),
+				s.repo.updateTodoList(ctx, tx),
+			)
+			return batchResult{list: list}, err
+
+		case *BatchOperation_CreateTodoItem:
+			in := o.CreateTodoItem
+			todoListID, err := ids.resolve(batchList, int(in.TodoListId), in.TodoListRef)
+			if err != nil {
+				return batchResult{}, err
+			}
+			parentID, err := ids.resolve(batchItem, int(in.ParentItemId), in.ParentItemRef)
+			if err != nil {
+				return batchResult{}, err
+			}
+
+			item, err := newTodoItemFromDTO(
+				todoListID, in.Description,
+				in.DueAt, in.RemindAt, in.Recurrence,
+				in.Priority, parentID,
+			)
+			if err != nil {
+				return batchResult{}, err
+			}
+
+			item, err = createTodoItem(
+				accountID, item,
+				s.repo.getTodoList(ctx, tx),
+				s.repo.getTodoItem(ctx, tx),
+				s.repo.getLastTodoItemPosition(ctx, tx),
+				s.repo.insertTodoItems(ctx, tx),
+			)
+			return batchResult{item: item}, err
+
+		case *BatchOperation_UpdateTodoItem:
+			in := o.UpdateTodoItem
+			id, err := ids.resolve(batchItem, int(in.Id), in.IdRef)
+			if err != nil {
+				return batchResult{}, err
+			}
+
+			changes, err := todoItemChangesFromDTO(in.Item, in.UpdateMask.GetPaths())
+			if err != nil {
+				return batchResult{}, err
+			}
+
+			loc, err := accountLocation(accountID, s.repo.getAccountTimezone(ctx, tx))
+			if err != nil {
+				return batchResult{}, err
+			}
+
+			item, _, err := updateTodoItem(
+				id, accountID,
+				changes, int(in.ExpectedVersion), loc,
+				s.repo.getTodoList(ctx, tx),
+				s.repo.getTodoItem(ctx, tx),
+				s.repo.updateTodoItem(ctx, tx),
+				s.repo.getLastTodoItemPosition(ctx, tx),
+				s.repo.insertTodoItems(ctx, tx),
+			)
+			return batchResult{item: item}, err
+
+		case *BatchOperation_MoveTodoItem:
+			in := o.MoveTodoItem
+			id, err := ids.resolve(batchItem, int(in.Id), in.IdRef)
+			if err != nil {
+				return batchResult{}, err
+			}
+			afterID, err := ids.resolve(batchItem, int(in.AfterId), in.AfterRef)
+			if err != nil {
+				return batchResult{}, err
+			}
+			beforeID, err := ids.resolve(batchItem, int(in.BeforeId), in.BeforeRef)
+			if err != nil {
+				return batchResult{}, err
+			}
+
+			item, err := moveTodoItem(
+				id, accountID, afterID, beforeID,
+				s.repo.getTodoList(ctx, tx),
+				s.repo.getTodoItem(ctx, tx),
+				s.repo.getNeighbourTodoItemPosition(ctx, tx),
+				s.repo.updateTodoItemPosition(ctx, tx),
+			)
+			return batchResult{item: item}, err
+
+		case *BatchOperation_DeleteTodoItem:
+			in := o.DeleteTodoItem
+			id, err := ids.resolve(batchItem, int(in.Id), in.IdRef)
+			if err != nil {
+				return batchResult{}, err
+			}
+
+			return batchResult{}, deleteTodoItem(
+				id, accountID,
+				s.repo.getTodoList(ctx, tx),
+				s.repo.getTodoItem(ctx, tx),
+				s.repo.deleteTodoItem(ctx, tx),
+			)
+		}
+		return batchResult{}, errInvalidInput
+	}
+
+	return batchOperation{tempID: op.TempId, run: run}
+}
+
+// Batch run many operations in one transaction
+func (s *Service) Batch(
+	ctx context.Context,
+	in *BatchRequest,
+) (*BatchResponse, error) {
+	accountID := getAccountID(ctx)
+
+	var results []batchResult
+	var err error
+
+	err = s.repo.transact(ctx, func(tx *sqlx.Tx) error {
+		operations := make([]batchOperation, 0, len(in.Operations))
+		for _, op := range in.Operations {
+			operations = append(operations, s.batchOperation(ctx, tx, accountID, op))
+		}
+
+		results, err = runBatch(operations)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	dtos := make([]*BatchResult, 0, len(results))
+	for i, r := range results {
+		dto := &BatchResult{TempId: in.Operations[i].TempId}
+		if r.list.id != 0 {
+			dto.Result = &BatchResult_TodoList{TodoList: domainTodoToDTO(r.list)}
+		} else if r.item.id != 0 {
+			dto.Result = &BatchResult_TodoItem{TodoItem: domainTodoItemToDTO(r.item)}
+		}
+		dtos = append(dtos, dto)
+	}
+
+	return &BatchResponse{Results: dtos}, nil
 }
@@ -0,0 +1,187 @@
+package todo
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Smart list is a saved search query, see searchNode, whose items are
+// found when it is read. Built-in smart lists are available to every
+// account, they have negative ids and can't be changed
+type smartList struct {
+	id        int
+	accountID int
+	name      string
+	query     string
+	createdAt time.Time
+}
+
+var builtinSmartLists = []smartList{
+	{id: -1, name: "Today", query: "is:open due:today"},
+	{id: -2, name: "Overdue", query: "is:overdue"},
+	{id: -3, name: "Upcoming 7 days", query: "is:open due:>=today due:<7d"},
+	{id: -4, name: "Completed this week", query: "is:completed completed:>=week"},
+}
+
+const maxSmartListQueryLength = 255
+
+type smartListSaver = func(accountID int, name, query string) (int, time.Time, error)
+type smartListGetter = func(id int) (smartList, error)
+type smartListUpdater = func(id int, name, query string) error
+type smartListDeleter = func(id int) error
+
+// ordered by name
+type smartListsByAccountSelecter = func(accountID int) ([]smartList, error)
+
+// the items of the account matching the query,
+// ordered by due date, without due date last, then id
+type smartListItemsPageSelecter = func(
+	accountID int, query searchNode, after []string, limit int,
+) ([]todoItem, error)
+
+func validateSmartList(name, query string) error {
+	if len(name) < 1 || len(name) > 50 ||
+		len(query) < 1 || len(query) > maxSmartListQueryLength {
+		return errInvalidInput
+	}
+
+	_, err := parseSearchQuery(query, time.Now())
+	return err
+}
+
+// the built-in smart lists then the ones of the account
+func selectSmartLists(accountID int, selecter smartListsByAccountSelecter) ([]smartList, error) {
+	saved, err := selecter(accountID)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]smartList(nil), builtinSmartLists...), saved...), nil
+}
+
+func getOwnedSmartList(id, accountID int, getter smartListGetter) (smartList, error) {
+	for _, builtin := range builtinSmartLists {
+		if builtin.id == id {
+			return builtin, nil
+		}
+	}
+
+	l, err := getter(id)
+	if err != nil {
+		return l, err
+	}
+
+	if l.accountID != accountID {
+		return l, errPermissionDenied
+	}
+	return l, nil
+}
+
+func createSmartList(
+	accountID int, name, query string,
+	saver smartListSaver,
+) (smartList, error) {
+	err := validateSmartList(name, query)
+	if err != nil {
+		return smartList{}, err
+	}
+
+	id, createdAt, err := saver(accountID, name, query)
+	return smartList{
+		id:        id,
+		accountID: accountID,
+		name:      name,
+		query:     query,
+		createdAt: createdAt,
+	}, err
+}
+
+func updateSmartList(
+	id, accountID int, name, query string,
+	getter smartListGetter,
+	updater smartListUpdater,
+) (smartList, error) {
+	err := validateSmartList(name, query)
+	if err != nil {
+		return smartList{}, err
+	}
+
+	if id < 0 {
+		return smartList{}, errPermissionDenied
+	}
+	l, err := getOwnedSmartList(id, accountID, getter)
+	if err != nil {
+		return l, err
+	}
+
+	l.name = name
+	l.query = query
+	return l, updater(id, name, query)
+}
+
+func deleteSmartList(
+	id, accountID int,
+	getter smartListGetter,
+	deleter smartListDeleter,
+) error {
+	if id < 0 {
+		return errPermissionDenied
+	}
+	_, err := getOwnedSmartList(id, accountID, getter)
+	if err != nil {
+		return err
+	}
+	return deleter(id)
+}
+
+func smartListItemsPageKeys(item todoItem) []string {
+	due := item.dueAt
+	if due.IsZero() {
+		due = noDueAtSortKey
+	}
+	return []string{due.UTC().Format(sortKeyTimeLayout), strconv.Itoa(item.id)}
+}
+
+// return the items of the smart list and the token of the next page,
+// relative days of the query are from now
+func selectSmartListItemsPage(
+	id, accountID int, now time.Time,
+	pageSize int, pageToken string,
+	codec pageTokenCodec,
+	getter smartListGetter,
+	selecter smartListItemsPageSelecter,
+) (smartList, []todoItem, string, error) {
+	size, err := normalizePageSize(pageSize)
+	if err != nil {
+		return smartList{}, nil, "", err
+	}
+
+	l, err := getOwnedSmartList(id, accountID, getter)
+	if err != nil {
+		return l, nil, "", err
+	}
+
+	node, err := parseSearchQuery(l.query, now)
+	if err != nil {
+		return l, nil, "", err
+	}
+
+	// a changed query makes the tokens of the previous one invalid
+	scope := fmt.Sprintf("smart_list:%d:%s", id, l.query)
+	after, err := codec.decode(scope, pageToken)
+	if err != nil {
+		return l, nil, "", err
+	}
+
+	items, err := selecter(accountID, node, after, size+1)
+	if err != nil {
+		return l, nil, "", err
+	}
+
+	if len(items) <= size {
+		return l, items, "", nil
+	}
+
+	items = items[:size]
+	return l, items, codec.encode(scope, smartListItemsPageKeys(items[size-1])), nil
+}
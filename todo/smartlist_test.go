@@ -0,0 +1,91 @@
+package todo
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBuiltinSmartLists(t *testing.T) {
+	now := time.Now()
+	for _, l := range builtinSmartLists {
+		if l.id >= 0 {
+			t.Errorf("%s: built-in smart list should have a negative id", l.name)
+		}
+		if _, err := parseSearchQuery(l.query, now); err != nil {
+			t.Errorf("%s: %v", l.name, err)
+		}
+	}
+}
+
+func TestParseSearchDayWeek(t *testing.T) {
+	sunday := time.Date(2026, 3, 15, 10, 0, 0, 0, time.UTC)
+	day, ok := parseSearchDay("week", sunday)
+	if !ok || !day.Equal(time.Date(2026, 3, 9, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("week should start on Monday, actual: %v", day)
+	}
+}
+
+func TestUpdateSmartList(t *testing.T) {
+	getter := func(id int) (smartList, error) {
+		return smartList{id: id, accountID: 10, name: "Work", query: "label:work"}, nil
+	}
+	updated := false
+	updater := func(id int, name, query string) error {
+		updated = true
+		return nil
+	}
+
+	_, err := updateSmartList(5, 10, "Work", "label:", getter, updater)
+	if _, ok := err.(*searchQueryError); !ok || updated {
+		t.Errorf("invalid query should not be saved, actual: %v", err)
+	}
+
+	_, err = updateSmartList(-1, 10, "Today", "due:today", getter, updater)
+	if err != errPermissionDenied || updated {
+		t.Errorf("built-in smart list should not be changed, actual: %v", err)
+	}
+
+	_, err = updateSmartList(5, 11, "Work", "label:work", getter, updater)
+	if err != errPermissionDenied || updated {
+		t.Errorf("smart list of another account should not be changed, actual: %v", err)
+	}
+
+	l, err := updateSmartList(5, 10, "Urgent work", "label:work priority:urgent", getter, updater)
+	if err != nil || !updated || l.name != "Urgent work" {
+		t.Errorf("should be updated, actual: %+v %v", l, err)
+	}
+}
+
+func TestSelectSmartListItemsPage(t *testing.T) {
+	codec := newPageTokenCodec([]byte("secret"))
+	getter := func(id int) (smartList, error) {
+		return smartList{}, nil
+	}
+	var after []string
+	selecter := func(accountID int, query searchNode, a []string, limit int) ([]todoItem, error) {
+		after = a
+		return []todoItem{
+			{id: 1, dueAt: time.Date(2026, 3, 10, 9, 0, 0, 0, time.UTC)},
+			{id: 2},
+			{id: 3},
+		}[:limit], nil
+	}
+
+	l, items, next, err := selectSmartListItemsPage(-2, 10, time.Now(), 2, "",
+		codec, getter, selecter)
+	if err != nil || l.name != "Overdue" || len(items) != 2 || next == "" {
+		t.Fatalf("should return the first page, actual: %v %v %q %v", l, items, next, err)
+	}
+
+	_, _, _, err = selectSmartListItemsPage(-2, 10, time.Now(), 2, next,
+		codec, getter, selecter)
+	if err != nil || len(after) != 2 || after[0] != "9999-12-31 23:59:59" || after[1] != "2" {
+		t.Errorf("should continue after the item without due date, actual: %v %v", after, err)
+	}
+
+	_, _, _, err = selectSmartListItemsPage(-1, 10, time.Now(), 2, next,
+		codec, getter, selecter)
+	if err != errInvalidPageToken {
+		t.Errorf("token of another smart list should be invalid, actual: %v", err)
+	}
+}
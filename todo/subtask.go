@@ -0,0 +1,200 @@
+package todo
+
+// Subtasks are todo items with a parent item in the same todo list,
+// a top level item can have subtasks which can have subtasks too
+const maxSubtaskDepth = 2
+
+// direct children of the items, ordered by position
+type todoItemChildrenSelecter = func(parentIDs []int) ([]todoItem, error)
+
+// 0 for a top level item
+func todoItemDepth(item todoItem, getter todoItemGetter) (int, error) {
+	depth := 0
+	for item.parentID != 0 && depth <= maxSubtaskDepth {
+		parent, err := getter(item.parentID)
+		if err != nil {
+			return depth, err
+		}
+		item = parent
+		depth++
+	}
+	return depth, nil
+}
+
+// the parent must be in the todo list and not too deep
+func validateSubtaskParent(parentID, todoListID int, getter todoItemGetter) error {
+	parent, err := getter(parentID)
+	if err != nil {
+		return err
+	}
+
+	if parent.todoListID != todoListID {
+		return errInvalidInput
+	}
+
+	depth, err := todoItemDepth(parent, getter)
+	if err != nil {
+		return err
+	}
+	if depth >= maxSubtaskDepth {
+		return errInvalidInput
+	}
+	return nil
+}
+
+func todoItemChildren(items []todoItem) map[int][]todoItem {
+	children := make(map[int][]todoItem)
+	for _, item := range items {
+		if item.parentID != 0 {
+			children[item.parentID] = append(children[item.parentID], item)
+		}
+	}
+	return children
+}
+
+// ids followed by the ids of the uncompleted subtasks of their items,
+// at any depth. items are all the items of the todo list
+func withUncompletedSubtasks(items []todoItem, ids []int) []int {
+	children := todoItemChildren(items)
+	seen := make(map[int]bool)
+	for _, id := range ids {
+		seen[id] = true
+	}
+
+	result := append([]int(nil), ids...)
+	for i := 0; i < len(result); i++ {
+		for _, child := range children[result[i]] {
+			if !child.completed && !seen[child.id] {
+				seen[child.id] = true
+				result = append(result, child.id)
+			}
+		}
+	}
+	return result
+}
+
+// the completed items which can be deleted: an item with an uncompleted
+// subtask is kept, so that deleting it doesn't delete the subtask
+func completedTodoItemsToDelete(items []todoItem) []int {
+	children := todoItemChildren(items)
+
+	var deletable func(item todoItem) bool
+	deletable = func(item todoItem) bool {
+		if !item.completed {
+			return false
+		}
+		for _, child := range children[item.id] {
+			if !deletable(child) {
+				return false
+			}
+		}
+		return true
+	}
+
+	result := make([]int, 0)
+	for _, item := range items {
+		if deletable(item) {
+			result = append(result, item.id)
+		}
+	}
+	return result
+}
+
+// each item followed by its subtasks not already in items, depth first.
+// Subtasks are loaded level by level
+func todoItemsWithSubtasks(
+	items []todoItem,
+	selecter todoItemChildrenSelecter,
+) ([]todoItem, error) {
+	seen := make(map[int]bool)
+	for _, item := range items {
+		seen[item.id] = true
+	}
+
+	children := make(map[int][]todoItem)
+	parentIDs := make([]int, 0, len(items))
+	for _, item := range items {
+		parentIDs = append(parentIDs, item.id)
+	}
+
+	for depth := 0; depth < maxSubtaskDepth && len(parentIDs) > 0; depth++ {
+		found, err := selecter(parentIDs)
+		if err != nil {
+			return nil, err
+		}
+
+		parentIDs = parentIDs[:0]
+		for _, child := range found {
+			if seen[child.id] {
+				continue
+			}
+			seen[child.id] = true
+			children[child.parentID] = append(children[child.parentID], child)
+			parentIDs = append(parentIDs, child.id)
+		}
+	}
+
+	result := make([]todoItem, 0, len(seen))
+	var appendTree func(item todoItem)
+	appendTree = func(item todoItem) {
+		result = append(result, item)
+		for _, child := range children[item.id] {
+			appendTree(child)
+		}
+	}
+	for _, item := range items {
+		appendTree(item)
+	}
+	return result, nil
+}
+
+// insert copies of the items level by level so that the parents
+// reference the new ids. A parent not among items makes a top level item
+func insertTodoItemTree(
+	todoListID int, items []todoItem,
+	inserter todoItemsInserter,
+) ([]todoItem, error) {
+	present := make(map[int]bool)
+	for _, item := range items {
+		present[item.id] = true
+	}
+
+	newIDs := make(map[int]int)
+	result := make([]todoItem, 0, len(items))
+	pending := items
+	for len(pending) > 0 {
+		level := make([]todoItem, 0)
+		oldIDs := make([]int, 0)
+		rest := make([]todoItem, 0)
+		for _, item := range pending {
+			if item.parentID != 0 && present[item.parentID] {
+				newID, ok := newIDs[item.parentID]
+				if !ok {
+					rest = append(rest, item)
+					continue
+				}
+				item.parentID = newID
+			} else {
+				item.parentID = 0
+			}
+			oldIDs = append(oldIDs, item.id)
+			level = append(level, item)
+		}
+
+		// a cycle of parents, which can't be inserted
+		if len(level) == 0 {
+			return result, errInvalidInput
+		}
+
+		inserted, err := inserter(todoListID, level)
+		if err != nil {
+			return result, err
+		}
+		for i, item := range inserted {
+			newIDs[oldIDs[i]] = item.id
+		}
+		result = append(result, inserted...)
+		pending = rest
+	}
+	return result, nil
+}
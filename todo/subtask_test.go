@@ -0,0 +1,139 @@
+package todo
+
+import (
+	"testing"
+	"time"
+)
+
+// 1 > 2 > 3 and 1 > 4, 5 is a top level item
+func subtaskTree() []todoItem {
+	return []todoItem{
+		{id: 1, todoListID: 1, position: "a"},
+		{id: 2, todoListID: 1, position: "b", parentID: 1},
+		{id: 3, todoListID: 1, position: "c", parentID: 2, completed: true},
+		{id: 4, todoListID: 1, position: "d", parentID: 1},
+		{id: 5, todoListID: 1, position: "e", completed: true},
+	}
+}
+
+func TestValidateSubtaskParent(t *testing.T) {
+	items := make(map[int]todoItem)
+	for _, item := range subtaskTree() {
+		items[item.id] = item
+	}
+	getter := func(id int) (todoItem, error) {
+		return items[id], nil
+	}
+
+	if err := validateSubtaskParent(2, 1, getter); err != nil {
+		t.Errorf("second level subtask should be valid, actual: %v", err)
+	}
+	if err := validateSubtaskParent(3, 1, getter); err != errInvalidInput {
+		t.Errorf("third level subtask should be invalid, actual: %v", err)
+	}
+	if err := validateSubtaskParent(1, 2, getter); err != errInvalidInput {
+		t.Errorf("parent in another list should be invalid, actual: %v", err)
+	}
+}
+
+func TestWithUncompletedSubtasks(t *testing.T) {
+	ids := withUncompletedSubtasks(subtaskTree(), []int{1})
+	if len(ids) != 3 || ids[0] != 1 || ids[1] != 2 || ids[2] != 4 {
+		t.Errorf("should add the uncompleted subtasks, actual: %v", ids)
+	}
+}
+
+func TestCompletedTodoItemsToDelete(t *testing.T) {
+	items := subtaskTree()
+	items[1].completed = true
+
+	ids := completedTodoItemsToDelete(items)
+	if len(ids) != 3 || ids[0] != 2 || ids[1] != 3 || ids[2] != 5 {
+		t.Errorf("should keep items with uncompleted subtasks, actual: %v", ids)
+	}
+}
+
+func TestMoveTodoItemsWithSubtasks(t *testing.T) {
+	items := make(map[int]todoItem)
+	for _, item := range subtaskTree() {
+		items[item.id] = item
+	}
+	listGetter := func(id int) (todoList, error) {
+		return todoList{id: id, accountID: 10}, nil
+	}
+	getter := func(id int) (todoItem, error) {
+		return items[id], nil
+	}
+	childrenSelecter := func(parentIDs []int) ([]todoItem, error) {
+		result := make([]todoItem, 0)
+		for _, item := range subtaskTree() {
+			for _, id := range parentIDs {
+				if item.parentID == id {
+					result = append(result, item)
+				}
+			}
+		}
+		return result, nil
+	}
+	neighbour := func(todoListID int, position string, after bool) (string, error) {
+		return "", nil
+	}
+	lastPosition := func(todoListID int) (string, error) {
+		return "", nil
+	}
+	mover := func(items []todoItem) (time.Time, error) {
+		return time.Now(), nil
+	}
+
+	result, err := moveTodoItems([]int{2}, 2, 10, 0, 0,
+		listGetter, getter, childrenSelecter, neighbour, lastPosition, mover)
+	if err != nil || len(result) != 2 {
+		t.Fatalf("should move the subtask with its subtask, actual: %v %v", result, err)
+	}
+	if result[0].id != 2 || result[0].parentID != 0 ||
+		result[1].id != 3 || result[1].parentID != 2 ||
+		!(result[0].position < result[1].position) {
+		t.Errorf("should detach the moved subtask only, actual: %+v", result)
+	}
+
+	_, err = moveTodoItems([]int{1}, 1, 10, 4, 0,
+		listGetter, getter, childrenSelecter, neighbour, lastPosition, mover)
+	if err != errInvalidInput {
+		t.Errorf("should not move after its own subtask, actual: %v", err)
+	}
+}
+
+func TestInsertTodoItemTree(t *testing.T) {
+	nextID := 100
+	calls := 0
+	inserter := func(todoListID int, items []todoItem) ([]todoItem, error) {
+		calls++
+		result := make([]todoItem, 0, len(items))
+		for _, item := range items {
+			nextID++
+			item.id = nextID
+			result = append(result, item)
+		}
+		return result, nil
+	}
+
+	// 3 is copied without its parent 2
+	items := subtaskTree()
+	items = append(items[:1], items[2:]...)
+
+	inserted, err := insertTodoItemTree(7, items, inserter)
+	if err != nil || len(inserted) != 4 || calls != 2 {
+		t.Fatalf("should insert two levels, actual: %v %v", inserted, err)
+	}
+
+	parents := make(map[string]int)
+	ids := make(map[string]int)
+	for _, item := range inserted {
+		parents[item.position] = item.parentID
+		ids[item.position] = item.id
+	}
+	if parents["a"] != 0 || parents["c"] != 0 || parents["e"] != 0 ||
+		parents["d"] != ids["a"] {
+		t.Errorf("should reference the new ids, actual: %v %v", parents, ids)
+	}
+}
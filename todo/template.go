@@ -0,0 +1,239 @@
+package todo
+
+import (
+	"regexp"
+	"time"
+)
+
+// Todo Template
+type todoTemplate struct {
+	id        int
+	accountID int
+	name      string
+	items     []todoTemplateItem
+	createdAt time.Time
+}
+
+type todoTemplateItem struct {
+	description string
+	position    string
+}
+
+type todoTemplateSaver = func(accountID int, name string) (int, time.Time, error)
+type todoTemplateItemsInserter = func(templateID int, items []todoTemplateItem) error
+
+// the template is returned with its items
+type todoTemplateGetter = func(id int) (todoTemplate, error)
+
+// return the templates owned by or shared with the account
+type todoTemplatesByAccountSelecter = func(accountID int) ([]todoTemplate, error)
+type todoTemplateShareChecker = func(templateID, accountID int) (bool, error)
+type todoTemplateSharer = func(templateID, accountID int) error
+type todoTemplateUnsharer = func(templateID, accountID int) error
+type todoTemplateDeleter = func(id int) error
+
+// return sql.ErrNoRows if no account has this username
+type accountIDGetter = func(username string) (int, error)
+
+var placeholderRegexp = regexp.MustCompile(`\{\{\s*([a-zA-Z_][a-zA-Z0-9_]*)\s*\}\}`)
+
+// replace {{name}} by values[name], unknown placeholders are kept
+func expandPlaceholders(text string, values map[string]string) string {
+	return placeholderRegexp.ReplaceAllStringFunc(text, func(match string) string {
+		name := placeholderRegexp.FindStringSubmatch(match)[1]
+		if value, ok := values[name]; ok {
+			return value
+		}
+		return match
+	})
+}
+
+// placeholders always available when instantiating a template,
+// the values given by the client take precedence
+func builtinPlaceholders(listName string, now time.Time) map[string]string {
+	return map[string]string{
+		"date": now.Format("2006-01-02"),
+		"name": listName,
+	}
+}
+
+func createTemplateFromList(
+	todoListID, accountID int, name string,
+	getter todoListGetter,
+	selecter todoItemSelecter,
+	saver todoTemplateSaver,
+	inserter todoTemplateItemsInserter,
+) (todoTemplate, error) {
+	if !validateTodoListName(name) {
+		return todoTemplate{}, errInvalidInput
+	}
+
+	todo, err := getter(todoListID)
+	if err != nil {
+		return todoTemplate{}, err
+	}
+
+	if todo.accountID != accountID {
+		return todoTemplate{}, errPermissionDenied
+	}
+
+	items, err := selecter(todoListID)
+	if err != nil {
+		return todoTemplate{}, err
+	}
+
+	templateItems := make([]todoTemplateItem, 0, len(items))
+	for _, item := range items {
+		templateItems = append(templateItems, todoTemplateItem{
+			description: item.description,
+			position:    item.position,
+		})
+	}
+
+	id, createdAt, err := saver(accountID, name)
+	if err != nil {
+		return todoTemplate{}, err
+	}
+
+	return todoTemplate{
+		id:        id,
+		accountID: accountID,
+		name:      name,
+		items:     templateItems,
+		createdAt: createdAt,
+	}, inserter(id, templateItems)
+}
+
+func canUseTemplate(
+	template todoTemplate, accountID int,
+	checker todoTemplateShareChecker,
+) (bool, error) {
+	if template.accountID == accountID {
+		return true, nil
+	}
+	return checker(template.id, accountID)
+}
+
+// create a new todo list named name from the template,
+// placeholders of the item descriptions are replaced by values
+func instantiateTemplate(
+	templateID, accountID int, name string,
+	values map[string]string, now time.Time,
+	getter todoTemplateGetter,
+	checker todoTemplateShareChecker,
+	lastPosition todoListLastPositionGetter,
+	saver todoListSaver,
+	inserter todoItemsInserter,
+) (todoList, int, error) {
+	if !validateTodoListName(name) {
+		return todoList{}, 0, errInvalidInput
+	}
+
+	template, err := getter(templateID)
+	if err != nil {
+		return todoList{}, 0, err
+	}
+
+	ok, err := canUseTemplate(template, accountID, checker)
+	if err != nil {
+		return todoList{}, 0, err
+	}
+	if !ok {
+		return todoList{}, 0, errPermissionDenied
+	}
+
+	placeholders := builtinPlaceholders(name, now)
+	for k, v := range values {
+		placeholders[k] = v
+	}
+
+	items := make([]todoItem, 0, len(template.items))
+	for _, templateItem := range template.items {
+		description := expandPlaceholders(templateItem.description, placeholders)
+		if !validateTodoItemDescription(description) {
+			return todoList{}, 0, errInvalidInput
+		}
+		items = append(items, todoItem{
+			description: description,
+			position:    templateItem.position,
+		})
+	}
+
+	todo, err := createTodoList(accountID, name, lastPosition, saver)
+	if err != nil {
+		return todo, 0, err
+	}
+
+	inserted, err := inserter(todo.id, items)
+	return todo, len(inserted), err
+}
+
+func getOwnedTemplate(
+	templateID, accountID int,
+	getter todoTemplateGetter,
+) (todoTemplate, error) {
+	template, err := getter(templateID)
+	if err != nil {
+		return template, err
+	}
+
+	if template.accountID != accountID {
+		return template, errPermissionDenied
+	}
+	return template, nil
+}
+
+// only the owner of a template can share it
+func shareTemplate(
+	templateID, accountID int, username string,
+	getter todoTemplateGetter,
+	accountGetter accountIDGetter,
+	sharer todoTemplateSharer,
+) error {
+	_, err := getOwnedTemplate(templateID, accountID, getter)
+	if err != nil {
+		return err
+	}
+
+	targetID, err := accountGetter(username)
+	if err != nil {
+		return err
+	}
+
+	if targetID == accountID {
+		return errInvalidInput
+	}
+
+	return sharer(templateID, targetID)
+}
+
+func unshareTemplate(
+	templateID, accountID int, username string,
+	getter todoTemplateGetter,
+	accountGetter accountIDGetter,
+	unsharer todoTemplateUnsharer,
+) error {
+	_, err := getOwnedTemplate(templateID, accountID, getter)
+	if err != nil {
+		return err
+	}
+
+	targetID, err := accountGetter(username)
+	if err != nil {
+		return err
+	}
+
+	return unsharer(templateID, targetID)
+}
+
+func deleteTemplate(
+	templateID, accountID int,
+	getter todoTemplateGetter,
+	deleter todoTemplateDeleter,
+) error {
+	_, err := getOwnedTemplate(templateID, accountID, getter)
+	if err != nil {
+		return err
+	}
+	return deleter(templateID)
+}
@@ -0,0 +1,73 @@
+package todo
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExpandPlaceholders(t *testing.T) {
+	values := map[string]string{"date": "2026-10-19", "who": "Tung"}
+
+	cases := []struct {
+		text, expected string
+	}{
+		{"release {{date}}", "release 2026-10-19"},
+		{"ask {{ who }} on {{date}}", "ask Tung on 2026-10-19"},
+		{"keep {{unknown}}", "keep {{unknown}}"},
+		{"no placeholder", "no placeholder"},
+	}
+
+	for _, c := range cases {
+		result := expandPlaceholders(c.text, values)
+		if result != c.expected {
+			t.Errorf("expand %q: expected %q, actual %q", c.text, c.expected, result)
+		}
+	}
+}
+
+func TestInstantiateTemplate(t *testing.T) {
+	now := time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)
+	getter := func(id int) (todoTemplate, error) {
+		return todoTemplate{
+			id:        id,
+			accountID: 10,
+			items: []todoTemplateItem{
+				{description: "tag release {{date}}", position: "a"},
+				{description: "notify {{team}}", position: "b"},
+			},
+		}, nil
+	}
+	shared := false
+	checker := func(templateID, accountID int) (bool, error) {
+		return shared, nil
+	}
+	lastPosition := func(accountID int) (string, error) {
+		return "", nil
+	}
+	saver := func(accountID int, name string, position string) (int, time.Time, error) {
+		return 7, now, nil
+	}
+	var inserted []todoItem
+	inserter := func(todoListID int, items []todoItem) ([]todoItem, error) {
+		inserted = items
+		return items, nil
+	}
+
+	_, _, err := instantiateTemplate(1, 11, "release 1.0", nil, now,
+		getter, checker, lastPosition, saver, inserter)
+	if err != errPermissionDenied {
+		t.Errorf("should be permission denied, actual: %v", err)
+	}
+
+	shared = true
+	values := map[string]string{"team": "backend"}
+	todo, count, err := instantiateTemplate(1, 11, "release 1.0", values, now,
+		getter, checker, lastPosition, saver, inserter)
+	if err != nil || todo.id != 7 || count != 2 {
+		t.Fatalf("should create the list, actual: %v %d %v", todo, count, err)
+	}
+	if inserted[0].description != "tag release 2026-10-19" ||
+		inserted[1].description != "notify backend" {
+		t.Errorf("should expand placeholders, actual: %v", inserted)
+	}
+}